@@ -2,47 +2,43 @@ package auth
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"time"
 
-	kafka "github.com/segmentio/kafka-go"
+	"github.com/Kwintenvdb/unity-publisher-management/common/events"
 )
 
-type schedulingJob struct {
-	Publisher     string `json:"publisher"`
-	KharmaSession string `json:"kharmaSession"`
-	KharmaToken   string `json:"kharmaToken"`
-	JWT           string `json:"jwt"`
-}
-
-func SendUserAuthenticatedMessage(publisher, session, token, jwt string) {
-	w := kafka.Writer{
-		Addr:     kafka.TCP("localhost:61162"),
-		Topic:    "user.authentications",
-		Balancer: &kafka.LeastBytes{},
+// SendUserAuthenticatedMessage tells the scheduler to cache the publisher's sales with the Unity session.
+func (p *Producer) SendUserAuthenticatedMessage(ctx context.Context, publisher, session, token string) error {
+	secrets, err := p.keys.Seal(events.SessionSecrets{KharmaSession: session, KharmaToken: token}, []byte(publisher))
+	if err != nil {
+		return err
 	}
-
-	job := schedulingJob{
-		Publisher:     publisher,
-		KharmaSession: session,
-		KharmaToken:   token,
-		JWT:           jwt,
+	job := events.UserAuthenticated{
+		Publisher: publisher,
+		Secrets:   secrets,
 	}
+	return p.publish(ctx, fmt.Sprintf("user.auth.%s", publisher), job)
+}
 
-	messageKey := fmt.Sprintf("user.auth.%s", publisher)
-	message, err := json.Marshal(job)
-	if err != nil {
-		panic(err)
+// SendSessionEndedMessage tells the scheduler to stop caching sales with the session of the given fingerprint,
+// or with any session of the publisher if the fingerprint is empty. The reason is one of the events.Reason constants.
+func (p *Producer) SendSessionEndedMessage(ctx context.Context, publisher, fingerprint, reason string) error {
+	ended := events.SessionEnded{
+		Publisher: publisher,
+		Session:   fingerprint,
+		Reason:    reason,
 	}
+	return p.publish(ctx, fmt.Sprintf("user.session.%s", publisher), ended)
+}
 
-	err = w.WriteMessages(context.Background(),
-		kafka.Message{
-			Key:   []byte(messageKey),
-			Value: message,
-		},
-	)
-
-	if err != nil {
-		panic(err)
+// SendSessionsEndedMessage tells the scheduler to stop caching sales with any session of the publisher that
+// logged in before the given time. The reason is one of the events.Reason constants.
+func (p *Producer) SendSessionsEndedMessage(ctx context.Context, publisher string, before time.Time, reason string) error {
+	ended := events.SessionEnded{
+		Publisher: publisher,
+		Before:    &before,
+		Reason:    reason,
 	}
+	return p.publish(ctx, fmt.Sprintf("user.session.%s", publisher), ended)
 }
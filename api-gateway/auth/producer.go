@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+	"github.com/Kwintenvdb/unity-publisher-management/common/envelope"
+	"github.com/Kwintenvdb/unity-publisher-management/common/events"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+	"github.com/Kwintenvdb/unity-publisher-management/common/messaging"
+	"github.com/Kwintenvdb/unity-publisher-management/common/requestid"
+	"github.com/Kwintenvdb/unity-publisher-management/common/tracing"
+)
+
+const (
+	retryBackoffMin = time.Second
+	retryBackoffMax = 30 * time.Second
+)
+
+var (
+	// ErrQueueFull is returned when events are published faster than the broker accepts them.
+	ErrQueueFull = errors.New("event queue is full")
+	// ErrProducerClosed is returned for events published after the producer was closed.
+	ErrProducerClosed = errors.New("producer is closed")
+)
+
+var publishedEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "upm",
+	Subsystem: "gateway",
+	Name:      "events_total",
+	Help:      "Events published by topic and result (published, retried or dropped).",
+}, []string{"topic", "result"})
+
+// event is a message waiting to be written. It carries the request id and the publish span of the
+// request that published it, which may have finished by the time the message is written.
+type event struct {
+	ctx     context.Context
+	span    trace.Span
+	message messaging.Message
+}
+
+// Producer publishes the gateway's events. Events are queued and published in order in the background,
+// so a broker outage delays them rather than failing the requests that publish them.
+// Publishing is retried until the broker accepts the event or the producer is closed.
+type Producer struct {
+	publisher messaging.Publisher
+	queue     chan event
+	keys      *envelope.KeyRing
+	logger    logger.Logger
+
+	// ctx is cancelled when Close gives up on the queued events.
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mutex  sync.RWMutex
+	closed bool
+}
+
+// NewProducer starts a producer that publishes through the publisher, holding up to queueSize events,
+// and encrypts secrets with the keys. It must be closed to publish the events still queued.
+func NewProducer(publisher messaging.Publisher, queueSize int, keys *envelope.KeyRing, log logger.Logger) *Producer {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Producer{
+		publisher: publisher,
+		queue:     make(chan event, queueSize),
+		keys:      keys,
+		logger:    log,
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// publish queues the event for the topic of its type. It only fails if the event cannot be encoded, or if
+// the queue is full or closed; the event is then lost.
+func (p *Producer) publish(ctx context.Context, key string, payload events.Payload) error {
+	topic, err := events.Topic(payload)
+	if err != nil {
+		return err
+	}
+	message, err := events.Marshal(config.Gateway, payload)
+	if err != nil {
+		return err
+	}
+
+	headers := messaging.Headers{requestid.Header: requestid.FromContext(ctx)}
+	_, span := tracing.StartPublish(ctx, topic, headers)
+	e := event{
+		ctx:  trace.ContextWithSpan(requestid.NewContext(context.Background(), requestid.FromContext(ctx)), span),
+		span: span,
+		// Events are keyed by publisher, so the events of a publisher stay in order.
+		message: messaging.Message{
+			Topic:   topic,
+			Key:     []byte(key),
+			Value:   message,
+			Headers: headers,
+		},
+	}
+
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	err = ErrProducerClosed
+	if !p.closed {
+		select {
+		case p.queue <- e:
+			return nil
+		default:
+			err = ErrQueueFull
+		}
+	}
+	publishedEvents.WithLabelValues(topic, "dropped").Inc()
+	tracing.RecordError(span, err)
+	span.End()
+	return err
+}
+
+func (p *Producer) run() {
+	defer close(p.done)
+	for e := range p.queue {
+		p.deliver(e)
+	}
+}
+
+// deliver publishes the event, backing off between failed attempts, until it is published or Close gives up.
+func (p *Producer) deliver(e event) {
+	defer e.span.End()
+	topic := e.message.Topic
+	log := logger.ForContext(p.logger, e.ctx).With("topic", topic, "key", string(e.message.Key))
+
+	backoff := retryBackoffMin
+	for {
+		err := p.publisher.Publish(p.ctx, e.message)
+		if err == nil {
+			publishedEvents.WithLabelValues(topic, "published").Inc()
+			return
+		}
+		if p.ctx.Err() == nil {
+			log.Warnw("Failed to publish event, retrying", "error", err, "backoff", backoff)
+			publishedEvents.WithLabelValues(topic, "retried").Inc()
+			select {
+			case <-time.After(backoff):
+			case <-p.ctx.Done():
+			}
+		}
+		if p.ctx.Err() != nil {
+			log.Errorw("Dropped event on shutdown", "error", err)
+			publishedEvents.WithLabelValues(topic, "dropped").Inc()
+			tracing.RecordError(e.span, err)
+			return
+		}
+
+		backoff *= 2
+		if backoff > retryBackoffMax {
+			backoff = retryBackoffMax
+		}
+	}
+}
+
+// Close stops accepting events and waits for the queued ones to be published until the context is done,
+// after which the remaining events are dropped.
+func (p *Producer) Close(ctx context.Context) error {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.queue)
+	p.mutex.Unlock()
+
+	var err error
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		p.cancel()
+		<-p.done
+		err = ctx.Err()
+	}
+	p.cancel()
+	if closeErr := p.publisher.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
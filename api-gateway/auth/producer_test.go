@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+	"github.com/Kwintenvdb/unity-publisher-management/common/envelope"
+	"github.com/Kwintenvdb/unity-publisher-management/common/events"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+	"github.com/Kwintenvdb/unity-publisher-management/common/messaging"
+	"github.com/Kwintenvdb/unity-publisher-management/common/requestid"
+)
+
+func TestSendUserAuthenticatedMessage(t *testing.T) {
+	broker := messaging.NewMemory()
+	subscriber := broker.Subscribe(events.AuthenticationsTopic, "test")
+	keys := testKeys(t)
+	producer := NewProducer(broker.Publisher(), 10, keys, logger.NewLogger())
+	defer producer.Close(context.Background())
+
+	ctx := requestid.NewContext(context.Background(), "request")
+	if err := producer.SendUserAuthenticatedMessage(ctx, "publisher", "session", "token"); err != nil {
+		t.Fatal(err)
+	}
+
+	m := receive(t, subscriber)
+	if string(m.Key) != "user.auth.publisher" {
+		t.Errorf("key = %q, want user.auth.publisher", m.Key)
+	}
+	if m.Headers[requestid.Header] != "request" {
+		t.Errorf("request id = %q, want request", m.Headers[requestid.Header])
+	}
+	var auth events.UserAuthenticated
+	event, err := events.Decode(m.Value, &auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Producer != string(config.Gateway) || auth.Publisher != "publisher" {
+		t.Errorf("event from %q for %q, want gateway and publisher", event.Producer, auth.Publisher)
+	}
+	var secrets events.SessionSecrets
+	if err := keys.Open(auth.Secrets, []byte("publisher"), &secrets); err != nil {
+		t.Fatal(err)
+	}
+	if secrets.KharmaSession != "session" || secrets.KharmaToken != "token" {
+		t.Errorf("secrets = %+v, want session and token", secrets)
+	}
+}
+
+func TestSendSessionEndedMessages(t *testing.T) {
+	broker := messaging.NewMemory()
+	subscriber := broker.Subscribe(events.SessionsEndedTopic, "test")
+	producer := NewProducer(broker.Publisher(), 10, testKeys(t), logger.NewLogger())
+	defer producer.Close(context.Background())
+
+	before := time.Now().UTC()
+	ctx := context.Background()
+	if err := producer.SendSessionEndedMessage(ctx, "publisher", "fingerprint", events.ReasonLogout); err != nil {
+		t.Fatal(err)
+	}
+	if err := producer.SendSessionsEndedMessage(ctx, "publisher", before, events.ReasonLogoutAll); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []events.SessionEnded{
+		{Publisher: "publisher", Session: "fingerprint", Reason: events.ReasonLogout},
+		{Publisher: "publisher", Before: &before, Reason: events.ReasonLogoutAll},
+	}
+	for _, want := range tests {
+		var ended events.SessionEnded
+		if _, err := events.Decode(receive(t, subscriber).Value, &ended); err != nil {
+			t.Fatal(err)
+		}
+		if ended.Publisher != want.Publisher || ended.Session != want.Session || ended.Reason != want.Reason || !sameTime(ended.Before, want.Before) {
+			t.Errorf("received %+v, want %+v", ended, want)
+		}
+	}
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func TestProducerCloseDeliversQueuedEvents(t *testing.T) {
+	broker := messaging.NewMemory()
+	subscriber := broker.Subscribe(events.SessionsEndedTopic, "test")
+	producer := NewProducer(broker.Publisher(), 10, testKeys(t), logger.NewLogger())
+
+	for i := 0; i < 3; i++ {
+		if err := producer.SendSessionEndedMessage(context.Background(), "publisher", "", events.ReasonLogout); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := producer.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		receive(t, subscriber)
+	}
+	if err := producer.SendSessionEndedMessage(context.Background(), "publisher", "", events.ReasonLogout); !errors.Is(err, ErrProducerClosed) {
+		t.Errorf("err = %v, want ErrProducerClosed", err)
+	}
+}
+
+func TestProducerDropsEventsWhenQueueIsFull(t *testing.T) {
+	producer := NewProducer(blockedPublisher{}, 1, testKeys(t), logger.NewLogger())
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		producer.Close(ctx)
+	}()
+
+	var err error
+	for i := 0; i < 3 && err == nil; i++ {
+		err = producer.SendSessionEndedMessage(context.Background(), "publisher", "", events.ReasonLogout)
+	}
+	if !errors.Is(err, ErrQueueFull) {
+		t.Errorf("err = %v, want ErrQueueFull", err)
+	}
+}
+
+// blockedPublisher never accepts a message.
+type blockedPublisher struct{}
+
+func (blockedPublisher) Publish(ctx context.Context, messages ...messaging.Message) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (blockedPublisher) Close() error {
+	return nil
+}
+
+func receive(t *testing.T, subscriber messaging.Subscriber) messaging.Message {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	m, err := subscriber.Receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func testKeys(t *testing.T) *envelope.KeyRing {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "test.key")
+	if err := os.WriteFile(file, []byte(base64.StdEncoding.EncodeToString(key)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := envelope.Load([]config.EncryptionKeyConfig{{ID: "test", File: file}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/Kwintenvdb/unity-publisher-management/api-gateway/proxy"
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+	"github.com/Kwintenvdb/unity-publisher-management/common/jwtauth"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+	"github.com/Kwintenvdb/unity-publisher-management/common/requestid"
+	"github.com/Kwintenvdb/unity-publisher-management/common/tracing"
+)
+
+const (
+	// Larger sales responses are not cached by the gateway, and are left to the scheduler.
+	maxFillSize = 4 << 20
+	// Cache misses beyond this many pending writes are left to the scheduler as well.
+	maxPendingFills = 32
+	fillTimeout     = 10 * time.Second
+)
+
+// cacheFiller writes the sales the api-service returned on a cache miss to the caching service,
+// in the background, so the next request is served from the cache without waiting for the scheduler.
+type cacheFiller struct {
+	host    string
+	issuer  *tokenIssuer
+	logger  logger.Logger
+	fetches sharedFetches
+	slots   chan struct{}
+	pending sync.WaitGroup
+}
+
+func newCacheFiller(host string, issuer *tokenIssuer, log logger.Logger) *cacheFiller {
+	return &cacheFiller{
+		host:   host,
+		issuer: issuer,
+		logger: log,
+		slots:  make(chan struct{}, maxPendingFills),
+	}
+}
+
+// salesMonth returns the publisher and month of a /sales/:publisher/:month path.
+func salesMonth(path string) (publisher, month string, ok bool) {
+	if !strings.HasPrefix(path, "/sales/") {
+		return "", "", false
+	}
+	parts := strings.Split(strings.TrimPrefix(path, "/sales/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// forward proxies a sales request that missed the cache, sharing the api-service call with concurrent
+// requests for the same month, and fills the cache with a successful response.
+func (f *cacheFiller) forward(upstream *proxy.Proxy, route proxy.Route, c *gin.Context, publisher, month string) {
+	res, fetched := f.fetches.fetch(upstream, route, c, publisher+"/sales/"+month)
+	res.writeTo(c)
+
+	// Requests that were handed the response of another request leave filling the cache to that request.
+	if !fetched || !res.complete || res.status != http.StatusOK || len(res.body) > maxFillSize || res.header.Get("Content-Encoding") != "" {
+		return
+	}
+	select {
+	case f.slots <- struct{}{}:
+	default:
+		cacheFills.WithLabelValues("dropped").Inc()
+		return
+	}
+
+	// The fill outlives the request, so it runs in a trace of its own linked to the request.
+	ctx := requestid.NewContext(context.Background(), requestid.FromContext(c.Request.Context()))
+	link := trace.Link{SpanContext: trace.SpanContextFromContext(c.Request.Context())}
+	sales := res.body
+	f.pending.Add(1)
+	go func() {
+		defer func() {
+			<-f.slots
+			f.pending.Done()
+		}()
+		f.fill(ctx, link, publisher, month, sales)
+	}()
+}
+
+func (f *cacheFiller) fill(ctx context.Context, link trace.Link, publisher, month string, sales []byte) {
+	ctx, cancel := context.WithTimeout(ctx, fillTimeout)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "sales cache fill",
+		trace.WithNewRoot(),
+		trace.WithLinks(link),
+		trace.WithAttributes(attribute.String("publisher", publisher), attribute.String("month", month)),
+	)
+	defer span.End()
+
+	err := f.post(ctx, publisher, month, sales)
+	if err != nil {
+		logger.ForContext(f.logger, ctx).Warnw("Failed to fill sales cache", "error", err, "publisher", publisher, "month", month)
+		cacheFills.WithLabelValues("failure").Inc()
+		tracing.RecordError(span, err)
+		return
+	}
+	cacheFills.WithLabelValues("success").Inc()
+}
+
+func (f *cacheFiller) post(ctx context.Context, publisher, month string, sales []byte) error {
+	token, err := f.issuer.serviceToken(config.CachingService)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://%s/sales/%s/%s", f.host, publisher, month), bytes.NewReader(sales))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(requestid.Header, requestid.FromContext(ctx))
+	jwtauth.SetBearer(req, token)
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+	return nil
+}
+
+// wait waits for the pending fills until the context is done.
+func (f *cacheFiller) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		f.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("gave up waiting for pending cache fills: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/Kwintenvdb/unity-publisher-management/api-gateway/proxy"
+)
+
+// upstreamResponse is a buffered api-service response that concurrent identical requests share.
+type upstreamResponse struct {
+	status int
+	header http.Header
+	body   []byte
+	// Whether the proxy wrote a response and the request was not cancelled. An incomplete response is
+	// neither handed to other requests nor cached.
+	complete bool
+}
+
+// writeTo sends the response to the client of the request. An incomplete response is not sent, as its
+// client is gone.
+func (r *upstreamResponse) writeTo(c *gin.Context) {
+	if !r.complete {
+		return
+	}
+	header := c.Writer.Header()
+	for name, values := range r.header {
+		header[name] = values
+	}
+	c.Writer.WriteHeader(r.status)
+	c.Writer.Write(r.body)
+}
+
+// bufferWriter collects the response of the proxy instead of sending it to the client.
+type bufferWriter struct {
+	gin.ResponseWriter
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferWriter) Header() http.Header { return w.header }
+
+func (w *bufferWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *bufferWriter) WriteHeaderNow() {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (w *bufferWriter) Write(data []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(data)
+}
+
+func (w *bufferWriter) WriteString(s string) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.WriteString(s)
+}
+
+func (w *bufferWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *bufferWriter) Size() int     { return w.body.Len() }
+func (w *bufferWriter) Written() bool { return w.status != 0 }
+func (w *bufferWriter) Flush()        {}
+
+// sharedFetches lets concurrent requests for the same resource share one api-service call.
+type sharedFetches struct {
+	group singleflight.Group
+}
+
+// fetch proxies the request, or waits for a concurrent request with the same key to be proxied, and returns
+// the buffered response. fetched reports whether this request made the call itself.
+// Only successful responses are shared: requests handed a failed response are proxied on their own, so that
+// one user's expired session or cancelled request does not fail the others.
+func (s *sharedFetches) fetch(upstream *proxy.Proxy, route proxy.Route, c *gin.Context, key string) (res *upstreamResponse, fetched bool) {
+	v, _, _ := s.group.Do(key, func() (interface{}, error) {
+		fetched = true
+		return record(upstream, route, c), nil
+	})
+	res = v.(*upstreamResponse)
+	trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.Bool("upstream.shared", !fetched))
+	if fetched {
+		return res, true
+	}
+
+	coalescedRequests.Inc()
+	if !res.complete || res.status != http.StatusOK {
+		return record(upstream, route, c), true
+	}
+	// Cookies set for the request that made the call are not meant for this user.
+	handed := &upstreamResponse{status: res.status, header: res.header.Clone(), body: res.body, complete: true}
+	handed.header.Del("Set-Cookie")
+	return handed, false
+}
+
+// record proxies the request into a buffer. The response is requested uncompressed so every client sharing it can read it.
+func record(upstream *proxy.Proxy, route proxy.Route, c *gin.Context) *upstreamResponse {
+	c.Request.Header.Del("Accept-Encoding")
+	writer := &bufferWriter{ResponseWriter: c.Writer, header: make(http.Header)}
+	c.Writer = writer
+	upstream.Forward(c, route)
+	c.Writer = writer.ResponseWriter
+	return &upstreamResponse{
+		status:   writer.Status(),
+		header:   writer.header,
+		body:     writer.body.Bytes(),
+		complete: writer.Written() && c.Request.Context().Err() == nil,
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Kwintenvdb/unity-publisher-management/api-gateway/proxy"
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+)
+
+// fetchResult is what a request got from sharedFetches.fetch.
+type fetchResult struct {
+	res     *upstreamResponse
+	fetched bool
+}
+
+func TestSharedFetches(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name string
+		// Status the upstream answers the first request with, or 0 to cancel that request instead.
+		leaderStatus int
+		// What the follower is handed, and how often the upstream is called in total.
+		followerBody    string
+		followerFetched bool
+		calls           int32
+	}{
+		{"shared success", http.StatusOK, "first", false, 1},
+		{"failed leader", http.StatusUnauthorized, "own", true, 2},
+		{"cancelled leader", 0, "own", true, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls atomic.Int32
+			arrived, release := make(chan struct{}), make(chan struct{})
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if calls.Add(1) > 1 {
+					w.Write([]byte("own"))
+					return
+				}
+				close(arrived)
+				select {
+				case <-release:
+				case <-r.Context().Done():
+					return
+				}
+				http.SetCookie(w, &http.Cookie{Name: "upstream", Value: "first"})
+				w.WriteHeader(test.leaderStatus)
+				w.Write([]byte("first"))
+			}))
+			defer server.Close()
+			upstream, err := proxy.New("api-service", server.URL, config.UpstreamConfig{Attempts: 1}, logger.NewLogger())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var fetches sharedFetches
+			leaderCtx, cancelLeader := context.WithCancel(context.Background())
+			defer cancelLeader()
+			leader, follower := make(chan fetchResult, 1), make(chan fetchResult, 1)
+			go func() {
+				res, fetched := fetches.fetch(upstream, proxy.Route{}, salesContext(leaderCtx), "publisher/sales/2023-06")
+				leader <- fetchResult{res, fetched}
+			}()
+			<-arrived
+			go func() {
+				res, fetched := fetches.fetch(upstream, proxy.Route{}, salesContext(context.Background()), "publisher/sales/2023-06")
+				follower <- fetchResult{res, fetched}
+			}()
+			// Give the follower time to wait for the call of the leader.
+			time.Sleep(50 * time.Millisecond)
+			if test.leaderStatus == 0 {
+				cancelLeader()
+			} else {
+				close(release)
+			}
+
+			led := <-leader
+			if !led.fetched || led.res.complete != (test.leaderStatus != 0) {
+				t.Errorf("leader fetched %v, complete %v", led.fetched, led.res.complete)
+			}
+			followed := <-follower
+			if followed.fetched != test.followerFetched || !followed.res.complete || followed.res.status != http.StatusOK || string(followed.res.body) != test.followerBody {
+				t.Errorf("follower fetched %v and got %d %q, want %v and %q", followed.fetched, followed.res.status, followed.res.body, test.followerFetched, test.followerBody)
+			}
+			if cookie := followed.res.header.Get("Set-Cookie"); cookie != "" {
+				t.Errorf("follower handed cookie %q of the leader", cookie)
+			}
+			if calls.Load() != test.calls {
+				t.Errorf("upstream called %d times, want %d", calls.Load(), test.calls)
+			}
+		})
+	}
+}
+
+// recorder is a response recorder httputil.ReverseProxy can serve, which needs an http.CloseNotifier.
+type recorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (recorder) CloseNotify() <-chan bool { return nil }
+
+func salesContext(ctx context.Context) *gin.Context {
+	c, _ := gin.CreateTestContext(recorder{httptest.NewRecorder()})
+	c.Request = httptest.NewRequest(http.MethodGet, "/sales/publisher/2023-06", nil).WithContext(ctx)
+	return c
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+	"github.com/Kwintenvdb/unity-publisher-management/common/health"
+	"github.com/Kwintenvdb/unity-publisher-management/common/messaging"
+)
+
+// readinessChecks returns the checks for the stores and services the gateway depends on.
+func readinessChecks(cfg *config.Config, revocations revocationStore, limits limitStore, broker messaging.Broker) []health.Check {
+	return []health.Check{
+		{Name: "revocations", Check: revocations.ping},
+		{Name: "rate-limits", Check: limits.ping},
+		{Name: "kafka", Check: broker.Ping},
+		{Name: "api-service", Check: checkService(cfg.ApiService.Host)},
+		{Name: "caching-service", Check: checkService(cfg.CachingService.Host)},
+	}
+}
+
+// checkService checks that the downstream service at host is alive.
+func checkService(host string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s/healthz", host), nil)
+		if err != nil {
+			return err
+		}
+		res, err := httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+		}
+		return nil
+	}
+}
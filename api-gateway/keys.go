@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gojwt "github.com/golang-jwt/jwt/v4"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+	"github.com/Kwintenvdb/unity-publisher-management/common/jwks"
+)
+
+type signingKey struct {
+	id         string
+	method     gojwt.SigningMethod
+	private    crypto.Signer
+	public     crypto.PublicKey
+	activeFrom time.Time
+}
+
+// keyRing holds the keys tokens are signed with. One key signs at a time. Keys are published
+// ahead of their activation and for as long as tokens they signed may still be in use.
+type keyRing struct {
+	keys          []signingKey // Ordered by activation.
+	tokenLifetime time.Duration
+	overlap       time.Duration
+}
+
+func loadKeyRing(cfg config.GatewayConfig, tokenLifetime time.Duration) (*keyRing, error) {
+	ring := &keyRing{
+		tokenLifetime: tokenLifetime,
+		overlap:       cfg.KeyOverlap,
+	}
+	for _, keyCfg := range cfg.SigningKeys {
+		key, err := loadSigningKey(keyCfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load signing key %s: %w", keyCfg.ID, err)
+		}
+		ring.keys = append(ring.keys, key)
+	}
+	sort.Slice(ring.keys, func(i, j int) bool {
+		return ring.keys[i].activeFrom.Before(ring.keys[j].activeFrom)
+	})
+	return ring, nil
+}
+
+func loadSigningKey(cfg config.SigningKeyConfig) (signingKey, error) {
+	private, err := jwks.LoadPrivateKey(cfg.File)
+	if err != nil {
+		return signingKey{}, err
+	}
+	algorithm, err := jwks.Algorithm(private.Public())
+	if err != nil {
+		return signingKey{}, err
+	}
+	return signingKey{
+		id:         cfg.ID,
+		method:     gojwt.GetSigningMethod(algorithm),
+		private:    private,
+		public:     private.Public(),
+		activeFrom: cfg.ActiveFrom,
+	}, nil
+}
+
+// active returns the key that signs tokens at the given time.
+func (r *keyRing) active(at time.Time) (*signingKey, error) {
+	for i := len(r.keys) - 1; i >= 0; i-- {
+		if !r.keys[i].activeFrom.After(at) {
+			return &r.keys[i], nil
+		}
+	}
+	return nil, errors.New("no signing key is active yet")
+}
+
+// published returns the keys that verify tokens at the given time. A retired key is dropped once
+// every token it signed expired, plus the overlap for verifiers that cached the key set.
+func (r *keyRing) published(at time.Time) []signingKey {
+	var keys []signingKey
+	for i, key := range r.keys {
+		if i+1 < len(r.keys) {
+			retired := r.keys[i+1].activeFrom
+			if at.After(retired.Add(r.tokenLifetime + r.overlap)) {
+				continue
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (r *keyRing) sign(claims gojwt.Claims) (string, error) {
+	key, err := r.active(time.Now())
+	if err != nil {
+		return "", err
+	}
+	token := gojwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+	return token.SignedString(key.private)
+}
+
+// Key implements jwtauth.KeySource, so the gateway verifies tokens like the other services do.
+func (r *keyRing) Key(ctx context.Context, kid string) (jwks.Key, error) {
+	for _, key := range r.published(time.Now()) {
+		if key.id == kid {
+			return jwks.NewKey(key.id, key.public)
+		}
+	}
+	return jwks.Key{}, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// jwksHandler publishes the verification keys. Verifiers may cache them for the overlap.
+func (r *keyRing) jwksHandler(c *gin.Context) {
+	set := jwks.Set{Keys: []jwks.Key{}}
+	for _, key := range r.published(time.Now()) {
+		jwk, err := jwks.NewKey(key.id, key.public)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Failed to encode keys")
+			return
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(r.overlap.Seconds())))
+	c.JSON(http.StatusOK, set)
+}
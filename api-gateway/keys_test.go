@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	gojwt "github.com/golang-jwt/jwt/v4"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/jwtauth"
+)
+
+func TestKeyRingRotation(t *testing.T) {
+	rotated := time.Date(2023, time.June, 1, 0, 0, 0, 0, time.UTC)
+	ring := &keyRing{
+		keys:          []signingKey{testSigningKey(t, "old", rotated.Add(-24*time.Hour)), testSigningKey(t, "new", rotated)},
+		tokenLifetime: time.Hour,
+		overlap:       5 * time.Minute,
+	}
+	tests := []struct {
+		name      string
+		at        time.Time
+		active    string
+		published []string
+	}{
+		{"before any key", rotated.Add(-48 * time.Hour), "", []string{"old", "new"}},
+		// The new key is published ahead of its activation, so verifiers know it once it signs.
+		{"before rotation", rotated.Add(-time.Minute), "old", []string{"old", "new"}},
+		// The old key verifies its tokens until they expire, and for the overlap of cached key sets.
+		{"after rotation", rotated.Add(time.Hour), "new", []string{"old", "new"}},
+		{"old tokens expired", rotated.Add(time.Hour + 5*time.Minute + time.Second), "new", []string{"new"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var active string
+			if key, err := ring.active(test.at); err == nil {
+				active = key.id
+			}
+			var published []string
+			for _, key := range ring.published(test.at) {
+				published = append(published, key.id)
+			}
+			if active != test.active || !equalStrings(published, test.published) {
+				t.Errorf("active %q, published %v, want %q, %v", active, published, test.active, test.published)
+			}
+		})
+	}
+}
+
+func TestKeyRingVerifiesPublishedKeys(t *testing.T) {
+	now := time.Now()
+	rotatedRecently := &keyRing{
+		keys:          []signingKey{testSigningKey(t, "old", now.Add(-2*time.Hour)), testSigningKey(t, "new", now.Add(-time.Minute))},
+		tokenLifetime: time.Hour,
+		overlap:       5 * time.Minute,
+	}
+	rotatedLongAgo := &keyRing{
+		keys:          []signingKey{rotatedRecently.keys[0], testSigningKey(t, "new", now.Add(-2*time.Hour+time.Minute))},
+		tokenLifetime: time.Hour,
+		overlap:       5 * time.Minute,
+	}
+	oldOnly := &keyRing{keys: rotatedRecently.keys[:1]}
+	tests := []struct {
+		name     string
+		signer   *keyRing
+		verifier *keyRing
+		verified bool
+	}{
+		{"active key", rotatedRecently, rotatedRecently, true},
+		{"retired key still published", oldOnly, rotatedRecently, true},
+		{"retired key no longer published", oldOnly, rotatedLongAgo, false},
+		// A key id the ring knows, but of another key.
+		{"rotated key of the same id", rotatedRecently, rotatedLongAgo, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token, err := test.signer.sign(jwtauth.Claims{RegisteredClaims: gojwt.RegisteredClaims{
+				Issuer:    "gateway",
+				Audience:  gojwt.ClaimStrings{"api"},
+				ExpiresAt: gojwt.NewNumericDate(now.Add(time.Minute)),
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = jwtauth.NewVerifier(test.verifier, "gateway", "api").Verify(context.Background(), token)
+			if verified := err == nil; verified != test.verified {
+				t.Errorf("verified = %v (%v), want %v", verified, err, test.verified)
+			}
+		})
+	}
+}
+
+func testSigningKey(t *testing.T, id string, activeFrom time.Time) signingKey {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingKey{
+		id:         id,
+		method:     gojwt.SigningMethodEdDSA,
+		private:    private,
+		public:     public,
+		activeFrom: activeFrom,
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
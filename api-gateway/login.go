@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Kwintenvdb/unity-publisher-management/api-gateway/auth"
+	"github.com/Kwintenvdb/unity-publisher-management/api-gateway/proxy"
+	"github.com/Kwintenvdb/unity-publisher-management/common/browser"
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+)
+
+// loginHandler forwards the credentials to the api-service, which logs the user in to Unity, and turns its
+// answer into a login at the gateway.
+func loginHandler(cfg *config.Config, issuer *tokenIssuer, upstream *proxy.Proxy, producer *auth.Producer, log logger.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// The rate limiter parsed the form, so the credentials are encoded again for the api-service.
+		form := url.Values{
+			"email":    {c.PostForm("email")},
+			"password": {c.PostForm("password")},
+		}.Encode()
+		c.Request.Body = io.NopCloser(strings.NewReader(form))
+		c.Request.ContentLength = int64(len(form))
+		c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		// The response is read by the gateway, so it must not be compressed.
+		c.Request.Header.Del("Accept-Encoding")
+
+		ctx := c.Request.Context()
+		upstream.Forward(c, proxy.Route{
+			Timeout: cfg.Gateway.Upstream.LoginTimeout,
+			Capture: func(res *http.Response) error {
+				return captureLogin(ctx, cfg, issuer, producer, logger.ForContext(log, ctx), res)
+			},
+		})
+	}
+}
+
+// captureLogin replaces the api-service's answer to a login with the gateway's: the user and tokens, and
+// the kharma cookies set again with the attributes of the gateway's origin. The api-service reads those
+// cookies from every proxied request.
+func captureLogin(ctx context.Context, cfg *config.Config, issuer *tokenIssuer, producer *auth.Producer, log logger.Logger, res *http.Response) error {
+	switch {
+	case res.StatusCode == http.StatusUnauthorized:
+		log.Infow("Failed to authenticate", "status", res.StatusCode)
+		return proxy.ReplaceJSON(res, http.StatusUnauthorized, gin.H{
+			"code":    http.StatusUnauthorized,
+			"message": "incorrect email or password",
+		})
+	case res.StatusCode != http.StatusOK:
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	var u user
+	if err := json.NewDecoder(res.Body).Decode(&u); err != nil {
+		return err
+	}
+	pair, err := issuer.issue(&u, time.Time{})
+	if err != nil {
+		log.Errorw("Failed to create token", "error", err)
+		return &proxy.Error{Status: http.StatusInternalServerError, Message: "failed to create token"}
+	}
+
+	cookies := res.Cookies()
+	res.Header.Del("Set-Cookie")
+	for _, cookie := range cookies {
+		res.Header.Add("Set-Cookie", browser.Cookie(cfg.Browser, cookie.Name, cookie.Value, 0).String())
+	}
+	for _, cookie := range issuer.tokenCookies(pair) {
+		res.Header.Add("Set-Cookie", cookie.String())
+	}
+
+	scheduleSalesCaching(ctx, producer, log, &u, cookies)
+
+	return proxy.ReplaceJSON(res, http.StatusOK, loginResponse(&u, pair))
+}
+
+// scheduleSalesCaching asks the scheduler to cache the user's sales. The login succeeds even if it cannot,
+// as the sales are then fetched on demand.
+func scheduleSalesCaching(ctx context.Context, producer *auth.Producer, log logger.Logger, user *user, cookies []*http.Cookie) {
+	var kharmaToken, kharmaSession string
+	for _, cookie := range cookies {
+		switch cookie.Name {
+		case "kharma_token":
+			kharmaToken = cookie.Value
+		case "kharma_session":
+			kharmaSession = cookie.Value
+		}
+	}
+	if err := producer.SendUserAuthenticatedMessage(ctx, user.PublisherId, kharmaSession, kharmaToken); err != nil {
+		log.Errorw("Failed to schedule sales caching", "error", err, "publisher", user.PublisherId)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Kwintenvdb/unity-publisher-management/api-gateway/auth"
+	"github.com/Kwintenvdb/unity-publisher-management/common/events"
+	"github.com/Kwintenvdb/unity-publisher-management/common/jwtauth"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+	"github.com/Kwintenvdb/unity-publisher-management/common/session"
+)
+
+// logouts revokes the tokens of a login, or of every login of a user, and tells the scheduler to stop caching
+// sales with the Unity sessions that ended.
+type logouts struct {
+	issuer   *tokenIssuer
+	verifier *jwtauth.Verifier
+	producer *auth.Producer
+	logger   logger.Logger
+}
+
+// logout revokes the access and refresh token of the request. An expired access token does not prevent
+// logging out, so the refresh token alone is enough.
+func (l *logouts) logout(c *gin.Context) {
+	ctx := c.Request.Context()
+	requestLog := logger.ForContext(l.logger, ctx)
+
+	var publisher string
+	tokens := []struct {
+		token    string
+		verifier *jwtauth.Verifier
+	}{
+		{jwtauth.TokenFromRequest(c.Request), l.verifier},
+		{refreshTokenFromRequest(c), l.issuer.refreshVerifier},
+	}
+	for _, t := range tokens {
+		if t.token == "" {
+			continue
+		}
+		claims, err := t.verifier.Verify(ctx, t.token)
+		if err != nil || claims.ID == "" {
+			continue
+		}
+		if _, err := l.issuer.revocations.revokeToken(ctx, claims.ID, claims.ExpiresAt.Time); err != nil {
+			requestLog.Errorw("Failed to revoke token", "error", err)
+			c.String(http.StatusServiceUnavailable, "Failed to log out")
+			return
+		}
+		publisher = claims.Publisher
+	}
+
+	// Clients without the kharma cookies cannot tell which session to end, so the publisher's job is dropped regardless.
+	var fingerprint string
+	if kharmaSession, err := c.Cookie("kharma_session"); err == nil && kharmaSession != "" {
+		fingerprint = session.Fingerprint(kharmaSession)
+	}
+	l.issuer.clearCookies(c)
+	if publisher != "" {
+		requestLog.Infow("Logged out", "publisher", publisher)
+		l.endSessions(c, publisher, fingerprint, events.ReasonLogout)
+	}
+	c.JSON(http.StatusOK, gin.H{"code": http.StatusOK, "message": "logged out"})
+}
+
+// logoutAll revokes every token issued to the user so far, on any device. Must run after jwtauth.Middleware.
+func (l *logouts) logoutAll(c *gin.Context) {
+	ctx := c.Request.Context()
+	claims, _ := jwtauth.ClaimsFromContext(ctx)
+
+	// Every token issued until now expires within the refresh token lifetime.
+	before := time.Now()
+	if err := l.issuer.revocations.revokeUser(ctx, claims.Subject, before, l.issuer.refreshLifetime); err != nil {
+		logger.ForContext(l.logger, ctx).Errorw("Failed to revoke tokens", "error", err)
+		c.String(http.StatusServiceUnavailable, "Failed to log out")
+		return
+	}
+
+	l.issuer.clearCookies(c)
+	logger.ForContext(l.logger, ctx).Infow("Logged out everywhere", "publisher", claims.Publisher)
+	// Sessions of logins after this one stay scheduled, even if the scheduler receives this event after theirs.
+	if err := l.producer.SendSessionsEndedMessage(ctx, claims.Publisher, before, events.ReasonLogoutAll); err != nil {
+		logger.ForContext(l.logger, ctx).Errorw("Failed to publish session end", "error", err, "publisher", claims.Publisher)
+	}
+	c.JSON(http.StatusOK, gin.H{"code": http.StatusOK, "message": "logged out everywhere"})
+}
+
+// endSessions tells the scheduler to drop the job of the session. The logout itself has already succeeded,
+// so a failure is only logged.
+func (l *logouts) endSessions(c *gin.Context, publisher, fingerprint, reason string) {
+	ctx := c.Request.Context()
+	if err := l.producer.SendSessionEndedMessage(ctx, publisher, fingerprint, reason); err != nil {
+		logger.ForContext(l.logger, ctx).Errorw("Failed to publish session end", "error", err, "publisher", publisher)
+	}
+}
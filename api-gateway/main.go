@@ -1,23 +1,32 @@
 package main
 
 import (
-	// "encoding/json"
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"io"
 	"strings"
 
 	"net/http"
 	"net/url"
-	"os"
-	"time"
 
-	"github.com/abrander/ginproxy"
-	jwt "github.com/appleboy/gin-jwt/v2"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/Kwintenvdb/unity-publisher-management/api-gateway/auth"
+	"github.com/Kwintenvdb/unity-publisher-management/api-gateway/proxy"
+	"github.com/Kwintenvdb/unity-publisher-management/common/browser"
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+	"github.com/Kwintenvdb/unity-publisher-management/common/envelope"
+	"github.com/Kwintenvdb/unity-publisher-management/common/health"
+	"github.com/Kwintenvdb/unity-publisher-management/common/jwks"
+	"github.com/Kwintenvdb/unity-publisher-management/common/jwtauth"
+	"github.com/Kwintenvdb/unity-publisher-management/common/lifecycle"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+	"github.com/Kwintenvdb/unity-publisher-management/common/messaging"
+	"github.com/Kwintenvdb/unity-publisher-management/common/requestid"
+	"github.com/Kwintenvdb/unity-publisher-management/common/tracing"
 )
 
 type user struct {
@@ -25,120 +34,137 @@ type user struct {
 	PublisherId string
 }
 
-type responseBodyWriter struct {
-	gin.ResponseWriter
-	body *bytes.Buffer
-}
-
-func (r *responseBodyWriter) Write(b []byte) (int, error) {
-	r.body.Write(b)
-	return r.ResponseWriter.Write(b)
-}
-
 func main() {
-	r := gin.Default()
-
-	proxy, _ := ginproxy.NewGinProxy("http://" + getApiServiceHost())
-
-	// NOTE: We need to invalidate the token somehow / log out the user
-	// if any of the Unity API endpoints return a 401
-	authMiddleware, err := jwt.New(&jwt.GinJWTMiddleware{
-		Realm:       "unity-publisher-management",
-		Key:         []byte("my temporary private secret key"),
-		Timeout:     time.Hour * 72, // 3 days
-		SendCookie:  true,
-		TokenLookup: "header:Authorization,cookie:jwt",
-		Authenticator: func(c *gin.Context) (interface{}, error) {
-			writer := &responseBodyWriter{
-				body:           &bytes.Buffer{},
-				ResponseWriter: c.Writer,
-			}
-			c.Writer = writer
+	cfg, err := config.Load(config.Gateway)
+	if err != nil {
+		panic(err)
+	}
 
-			proxy.Handler(c)
+	log := logger.NewLogger()
+	defer log.Sync()
 
-			if c.Writer.Status() != http.StatusOK {
-				return nil, jwt.ErrFailedAuthentication
-			}
+	shutdownTracing, err := tracing.Setup(config.Gateway, cfg.Tracing)
+	if err != nil {
+		panic(err)
+	}
 
-			var u user
-			err := json.NewDecoder(writer.body).Decode(&u)
-			if err != nil {
-				return nil, jwt.ErrFailedAuthentication
-			}
-			return u, nil
-		},
-		LoginResponse: func(c *gin.Context, code int, token string, expire time.Time) {
-			user := c.MustGet("user").(*user)
-
-			scheduleSalesCaching(c, user, token)
-
-			c.JSON(http.StatusOK, gin.H{
-				"email":       user.Email,
-				"publisherId": user.PublisherId,
-				"token":       token,
-				"expire":      expire.Format(time.RFC3339),
-			})
-		},
-	})
+	r := gin.New()
+	if err := r.SetTrustedProxies(cfg.Gateway.TrustedProxies); err != nil {
+		panic(err)
+	}
+	r.Use(gin.Recovery(), otelgin.Middleware(string(config.Gateway)), requestid.Middleware(), logger.AccessLog(log))
+	r.Use(browser.CORS(cfg.Browser), browser.CheckOrigin(cfg.Browser))
+
+	// Keys must stay published for as long as the longest lived tokens they sign.
+	keys, err := loadKeyRing(cfg.Gateway, cfg.Gateway.RefreshTokenLifetime)
+	if err != nil {
+		panic(err)
+	}
+	redisClient := sharedRedis(cfg.Redis)
+	revocations := newRevocationStore(cfg, redisClient)
+	limits := newLimitStore(cfg, redisClient)
+	limiter := &rateLimiter{store: limits, limits: cfg.Gateway.RateLimit, logger: log}
+	issuer := newTokenIssuer(keys, revocations, cfg, log)
+	verifier := jwtauth.NewVerifier(keys, cfg.Auth.Issuer, cfg.Auth.Audience)
+	messageKeys, err := envelope.Load(cfg.Kafka.EncryptionKeys)
 	if err != nil {
 		panic(err)
 	}
+	broker := messaging.New(cfg.Kafka)
+	producer := auth.NewProducer(broker.Publisher(), cfg.Kafka.ProducerQueue, messageKeys, log)
+	logouts := &logouts{issuer: issuer, verifier: verifier, producer: producer, logger: log}
+	filler := newCacheFiller(cfg.CachingService.Host, issuer, log)
+	// The gateway's tokens stay with the gateway; the api-service gets the user's token as a bearer token.
+	upstream, err := proxy.New(string(config.ApiService), "http://"+cfg.ApiService.Host, cfg.Gateway.Upstream, log, jwtauth.CookieName, refreshCookie)
+	if err != nil {
+		panic(err)
+	}
+	apiRoute := proxy.Route{Timeout: cfg.Gateway.Upstream.ApiTimeout}
 
-	r.POST("/authenticate", authMiddleware.LoginHandler)
+	r.GET("/healthz", gin.WrapF(health.Healthz))
+	r.GET("/readyz", gin.WrapF(health.Readyz(readinessChecks(cfg, revocations, limits, broker)...)))
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
+	r.GET(jwks.Path, keys.jwksHandler)
+
+	r.POST("/authenticate", limiter.limitLogins, loginHandler(cfg, issuer, upstream, producer, log))
+	r.POST("/refresh", issuer.refreshHandler)
+	r.POST("/logout", logouts.logout)
+	r.POST("/logout-all", jwtauth.Middleware(verifier), rejectRevoked(revocations, log), logouts.logoutAll)
 
 	// Automatically proxy all api requests to API service
 	authGroup := r.Group("/api")
-	authGroup.Use(authMiddleware.MiddlewareFunc())
+	authGroup.Use(jwtauth.Middleware(verifier), rejectRevoked(revocations, log), limiter.limitApi, requirePublisherOwnership(log), invalidateExpiredSessions(issuer, producer, log))
 
 	authGroup.Any("*any", func(c *gin.Context) {
 		path := c.Param("any")
 
+		requestLog := logger.ForContext(log, c.Request.Context())
+
 		// Check cache for sales API
 		if strings.HasPrefix(path, "/sales") {
-			println("sales path", path)
 			// Check the caching service first, then forward to API service if not found
-			err := fetchSalesFromCache(path, c)
+			err := fetchSalesFromCache(requestLog, cfg.CachingService.Host, path, c)
 			if err == nil {
 				return
 			}
 		}
 
-		println("Proxying request to API service...")
-		proxy.Handler(c)
+		requestLog.Debugw("Proxying request to API service", "path", path)
+		if publisher, month, ok := salesMonth(path); ok && c.Request.Method == http.MethodGet {
+			filler.forward(upstream, apiRoute, c, publisher, month)
+			return
+		}
+		upstream.Forward(c, apiRoute)
 	})
 
-	r.Run(":8080")
+	assets, err := newStaticAssets(cfg.Gateway.StaticDir, cfg.Gateway.ContentSecurityPolicy)
+	if err != nil {
+		panic(err)
+	}
+	if assets != nil {
+		r.NoRoute(assets.handler)
+	} else {
+		log.Info("No frontend build found, serving the API only")
+	}
+
+	// Pending cache fills and queued events are waited for first so their spans are flushed as well.
+	lifecycle.Serve(&http.Server{
+		Addr:    cfg.Gateway.Addr,
+		Handler: r,
+	}, cfg.ShutdownTimeout, log, func(ctx context.Context) error {
+		return errors.Join(filler.wait(ctx), producer.Close(ctx), shutdownTracing(ctx))
+	})
 }
 
-func fetchSalesFromCache(path string, c *gin.Context) error {
-	cacheUrl, _ := url.JoinPath("http://localhost:8082", path)
-	res, err := http.Get(cacheUrl)
+func fetchSalesFromCache(log logger.Logger, cacheHost, path string, c *gin.Context) error {
+	ctx, span := tracer.Start(c.Request.Context(), "sales cache lookup")
+	defer span.End()
+
+	cacheUrl, _ := url.JoinPath("http://"+cacheHost, path)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, cacheUrl, nil)
+	req.Header.Set(requestid.Header, requestid.FromContext(ctx))
+	jwtauth.SetBearer(req, jwtauth.TokenFromContext(ctx))
+
+	res, err := httpClient.Do(req)
 	if err != nil {
-		println("Failed to fetch sales")
+		log.Warnw("Failed to fetch sales from cache", "error", err, "path", path)
+		cacheRequests.WithLabelValues("error").Inc()
+		tracing.RecordError(span, err)
 		return err
 	}
+	defer res.Body.Close()
+
 	if res.StatusCode == http.StatusOK {
-		println("Retrieved sales from cache")
+		log.Debugw("Retrieved sales from cache", "path", path)
+		cacheRequests.WithLabelValues("hit").Inc()
+		span.SetAttributes(attribute.String("cache.result", "hit"))
 
-		defer res.Body.Close()
 		body, _ := io.ReadAll(res.Body)
 		c.Data(http.StatusOK, "application/json", body)
 		return nil
 	}
+	cacheRequests.WithLabelValues("miss").Inc()
+	span.SetAttributes(attribute.String("cache.result", "miss"))
 	return errors.New("sales not found in cache")
 }
-
-func scheduleSalesCaching(c *gin.Context, user *user, token string) {
-	kharmaToken, _ := c.Cookie("kharma_token")
-	kharmaSession, _ := c.Cookie("kharma_session")
-	auth.SendUserAuthenticatedMessage(user.PublisherId, kharmaSession, kharmaToken, token)
-}
-
-func getApiServiceHost() string {
-	if host, found := os.LookupEnv("UPM_API_SERVICE"); found {
-		return host
-	}
-	return "localhost:8081"
-}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "upm",
+	Subsystem: "gateway",
+	Name:      "cache_requests_total",
+	Help:      "Sales lookups in the caching service by result (hit, miss or error).",
+}, []string{"result"})
+
+var cacheFills = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "upm",
+	Subsystem: "gateway",
+	Name:      "cache_fills_total",
+	Help:      "Sales written to the caching service after a cache miss by result (success, failure or dropped).",
+}, []string{"result"})
+
+var coalescedRequests = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "upm",
+	Subsystem: "gateway",
+	Name:      "coalesced_requests_total",
+	Help:      "Sales requests that missed the cache and were answered with the response of a concurrent identical request.",
+})
+
+var rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "upm",
+	Subsystem: "gateway",
+	Name:      "rate_limited_total",
+	Help:      "Requests refused with 429 by route (login or api).",
+}, []string{"route"})
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/jwtauth"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+)
+
+// API resources whose first path parameter is a publisher id, e.g. /api/sales/:publisher/:month.
+var publisherResources = map[string]bool{
+	"sales":     true,
+	"months":    true,
+	"forecast":  true,
+	"anomalies": true,
+}
+
+// requirePublisherOwnership rejects requests for another publisher's data than the one the token was issued to.
+// Tokens issued without a publisher claim are rejected for publisher resources as well.
+func requirePublisherOwnership(logger logger.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		segments := strings.Split(strings.Trim(c.Param("any"), "/"), "/")
+		if len(segments) < 2 || !publisherResources[segments[0]] {
+			c.Next()
+			return
+		}
+
+		requested := segments[1]
+		claims, ok := jwtauth.ClaimsFromContext(c.Request.Context())
+		if !ok {
+			claims = &jwtauth.Claims{}
+		}
+		if claims.Publisher != "" && claims.Publisher == requested {
+			c.Next()
+			return
+		}
+
+		logger.Warnw("Denied access to publisher",
+			"audit", true,
+			"email", claims.Email,
+			"publisher", claims.Publisher,
+			"requested_publisher", requested,
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"client_ip", c.ClientIP(),
+		)
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"code":    http.StatusForbidden,
+			"message": "access to this publisher is not allowed",
+		})
+	}
+}
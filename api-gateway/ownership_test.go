@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gojwt "github.com/golang-jwt/jwt/v4"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/jwtauth"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+)
+
+func TestRequirePublisherOwnership(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ring := &keyRing{keys: []signingKey{testSigningKey(t, "key", time.Time{})}}
+	tests := []struct {
+		name      string
+		publisher string
+		path      string
+		status    int
+	}{
+		{"own sales", "publisher", "/api/sales/publisher/2023-06", http.StatusOK},
+		{"own forecast", "publisher", "/api/forecast/publisher/2023-06", http.StatusOK},
+		{"other publisher's sales", "publisher", "/api/sales/other/2023-06", http.StatusForbidden},
+		{"other publisher's months", "publisher", "/api/months/other", http.StatusForbidden},
+		{"other publisher's anomalies", "publisher", "/api/anomalies/other", http.StatusForbidden},
+		{"token without publisher", "", "/api/sales/publisher/2023-06", http.StatusForbidden},
+		// Resources without a publisher in their path are left to the api-service.
+		{"packages", "", "/api/packages", http.StatusOK},
+		{"resource without publisher", "publisher", "/api/sales", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := gin.New()
+			api := r.Group("/api")
+			api.Use(jwtauth.Middleware(jwtauth.NewVerifier(ring, "gateway", "api")), requirePublisherOwnership(logger.NewLogger()))
+			api.Any("*any", func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			token, err := ring.sign(jwtauth.Claims{
+				RegisteredClaims: gojwt.RegisteredClaims{
+					Issuer:    "gateway",
+					Audience:  gojwt.ClaimStrings{"api"},
+					ExpiresAt: gojwt.NewNumericDate(time.Now().Add(time.Minute)),
+				},
+				Publisher: test.publisher,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, test.path, nil)
+			jwtauth.SetBearer(req, token)
+			r.ServeHTTP(w, req)
+			if w.Code != test.status {
+				t.Errorf("status = %d, want %d", w.Code, test.status)
+			}
+		})
+	}
+}
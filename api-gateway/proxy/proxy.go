@@ -0,0 +1,219 @@
+// Package proxy forwards requests from the gateway to the services behind it, keeping the gateway's
+// credentials to itself and reporting upstream failures as JSON.
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+	"github.com/Kwintenvdb/unity-publisher-management/common/jwtauth"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+	"github.com/Kwintenvdb/unity-publisher-management/common/requestid"
+)
+
+// Headers that only describe the connection to the gateway, or that clients could use to pose as a proxy.
+// Hop-by-hop headers are removed by httputil.ReverseProxy itself.
+var scrubbedRequestHeaders = []string{"Forwarded", "X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto", "X-Real-Ip"}
+
+// Headers of the upstream that tell clients more about the services than they need to know.
+var scrubbedResponseHeaders = []string{"Server", "X-Powered-By"}
+
+// Error fails a proxied request with the given status and message.
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Route configures how the requests of a route are forwarded.
+type Route struct {
+	// Timeout bounds the whole exchange with the upstream, including streaming the response. Zero means none.
+	Timeout time.Duration
+	// Capture, if set, may read and rewrite the upstream response before it is sent to the client.
+	// An error fails the request with 502, or with the status of an *Error.
+	Capture func(res *http.Response) error
+}
+
+// Proxy forwards requests to a single upstream.
+type Proxy struct {
+	name           string
+	target         *url.URL
+	transport      http.RoundTripper
+	privateCookies map[string]bool
+	logger         logger.Logger
+	errorLog       *log.Logger
+}
+
+// New returns a proxy to the upstream at the target URL. The private cookies are the gateway's own,
+// which are never forwarded to the upstream nor accepted from it.
+func New(name, target string, cfg config.UpstreamConfig, log logger.Logger, privateCookies ...string) (*Proxy, error) {
+	targetUrl, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if targetUrl.Scheme == "" || targetUrl.Host == "" {
+		return nil, errors.New("upstream " + target + " is not an absolute URL")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Every request of the gateway goes to the same few hosts.
+	transport.MaxIdleConnsPerHost = 32
+
+	p := &Proxy{
+		name:   name,
+		target: targetUrl,
+		transport: otelhttp.NewTransport(
+			&retryTransport{next: transport, attempts: cfg.Attempts, backoff: cfg.RetryBackoff, name: name},
+			otelhttp.WithSpanNameFormatter(func(_ string, req *http.Request) string {
+				return name + " " + req.Method
+			}),
+		),
+		privateCookies: make(map[string]bool),
+		logger:         log,
+	}
+	for _, cookie := range privateCookies {
+		p.privateCookies[cookie] = true
+	}
+	p.errorLog = newErrorLog(p)
+	return p, nil
+}
+
+// Forward proxies the request of the context to the upstream. The response is streamed to the client
+// as it arrives.
+func (p *Proxy) Forward(c *gin.Context, route Route) {
+	req := c.Request
+	if route.Timeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), route.Timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
+	reverse := &httputil.ReverseProxy{
+		Director:      p.direct,
+		Transport:     p.transport,
+		FlushInterval: -1,
+		ModifyResponse: func(res *http.Response) error {
+			p.scrubResponse(res)
+			if route.Capture != nil {
+				return route.Capture(res)
+			}
+			return nil
+		},
+		ErrorHandler: p.handleError,
+		ErrorLog:     p.errorLog,
+	}
+	reverse.ServeHTTP(c.Writer, req)
+}
+
+// direct points the outgoing request at the upstream. The upstream learns who the user is from the bearer
+// token the gateway verified, never from the gateway's cookies or an unverified Authorization header.
+func (p *Proxy) direct(req *http.Request) {
+	// The Host header stays that of the gateway, so the upstream checks the Origin of browser requests
+	// against the origin the browser actually called.
+	req.URL.Scheme = p.target.Scheme
+	req.URL.Host = p.target.Host
+
+	for _, name := range scrubbedRequestHeaders {
+		req.Header.Del(name)
+	}
+	if token := jwtauth.TokenFromContext(req.Context()); token != "" {
+		jwtauth.SetBearer(req, token)
+	} else {
+		req.Header.Del("Authorization")
+	}
+	cookies := req.Cookies()
+	req.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if !p.privateCookies[cookie.Name] {
+			req.AddCookie(cookie)
+		}
+	}
+	req.Header.Set(requestid.Header, requestid.FromContext(req.Context()))
+}
+
+// scrubResponse drops the headers the client should not see, and cookies that would overwrite the gateway's.
+func (p *Proxy) scrubResponse(res *http.Response) {
+	for _, name := range scrubbedResponseHeaders {
+		res.Header.Del(name)
+	}
+	setCookies := res.Header.Values("Set-Cookie")
+	res.Header.Del("Set-Cookie")
+	for _, setCookie := range setCookies {
+		name, _, _ := strings.Cut(setCookie, "=")
+		if !p.privateCookies[strings.TrimSpace(name)] {
+			res.Header.Add("Set-Cookie", setCookie)
+		}
+	}
+}
+
+// handleError answers a request the upstream did not: 504 if it ran out of time, 502 otherwise.
+// Requests cancelled by the client get no answer, as there is no one left to read it.
+func (p *Proxy) handleError(w http.ResponseWriter, req *http.Request, err error) {
+	status, message := http.StatusBadGateway, "upstream unavailable"
+	var proxyErr *Error
+	switch {
+	case errors.As(err, &proxyErr):
+		status, message = proxyErr.Status, proxyErr.Message
+	case errors.Is(req.Context().Err(), context.DeadlineExceeded) || errors.Is(err, context.DeadlineExceeded):
+		status, message = http.StatusGatewayTimeout, "upstream timed out"
+	case errors.Is(req.Context().Err(), context.Canceled):
+		return
+	}
+
+	requestLog := logger.ForContext(p.logger, req.Context())
+	if status >= http.StatusInternalServerError {
+		requestLog.Warnw("Failed to proxy request", "upstream", p.name, "status", status, "error", err)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(gin.H{"code": status, "message": message})
+}
+
+// ReplaceJSON replaces the status and body of an upstream response with v encoded as JSON.
+func ReplaceJSON(res *http.Response, status int, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	res.StatusCode = status
+	res.Status = strconv.Itoa(status) + " " + http.StatusText(status)
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	res.ContentLength = int64(len(body))
+	res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	res.Header.Set("Content-Type", "application/json; charset=utf-8")
+	res.Header.Del("Content-Encoding")
+	return nil
+}
+
+// errorWriter logs what httputil.ReverseProxy reports after the response started, such as a response
+// cut short by the route timeout.
+type errorWriter struct {
+	proxy *Proxy
+}
+
+func (w errorWriter) Write(line []byte) (int, error) {
+	w.proxy.logger.Warnw("Failed to proxy response", "upstream", w.proxy.name, "error", strings.TrimSpace(string(line)))
+	return len(line), nil
+}
+
+func newErrorLog(p *Proxy) *log.Logger {
+	return log.New(errorWriter{proxy: p}, "", 0)
+}
@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var retries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "upm",
+	Subsystem: "gateway",
+	Name:      "upstream_retries_total",
+	Help:      "GET requests sent to an upstream again after it failed or answered 502, 503 or 504, by upstream.",
+}, []string{"upstream"})
+
+// retryTransport retries GET and HEAD requests that failed or got 502, 503 or 504, as an upstream answers
+// while it restarts. Other requests are sent once, as they may have taken effect even if they failed.
+type retryTransport struct {
+	next     http.RoundTripper
+	attempts int
+	backoff  time.Duration
+	name     string
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	idempotent := req.Method == http.MethodGet || req.Method == http.MethodHead
+	if !idempotent || req.Body != nil && req.Body != http.NoBody {
+		return t.next.RoundTrip(req)
+	}
+
+	backoff := t.backoff
+	for attempt := 1; ; attempt++ {
+		res, err := t.next.RoundTrip(req)
+		if attempt >= t.attempts || !retryable(res, err) {
+			return res, err
+		}
+		if res != nil {
+			io.Copy(io.Discard, io.LimitReader(res.Body, 4<<10))
+			res.Body.Close()
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+		backoff *= 2
+		retries.WithLabelValues(t.name).Inc()
+	}
+}
+
+// retryable reports whether the upstream may answer the request if it is sent again.
+// Requests that ran out of time or were cancelled are not.
+func retryable(res *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	switch res.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
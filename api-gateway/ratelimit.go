@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+	"github.com/Kwintenvdb/unity-publisher-management/common/jwtauth"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+)
+
+const limitPrefix = "upm:ratelimit:"
+
+// limitStore keeps the counters of the rate limits. Keys expire on their own.
+type limitStore interface {
+	// incr counts a hit against the key and returns the hits so far and the time until the count resets.
+	// The window starts with the first hit.
+	incr(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error)
+	// set stores the key for the duration.
+	set(ctx context.Context, key string, ttl time.Duration) error
+	// ttl returns how much longer the key is stored, or 0 if it is not.
+	ttl(ctx context.Context, key string) (time.Duration, error)
+	del(ctx context.Context, keys ...string) error
+	ping(ctx context.Context) error
+}
+
+func newLimitStore(cfg *config.Config, redisClient func() *redis.Client) limitStore {
+	if cfg.Gateway.RateLimit.Store == "redis" {
+		return &redisLimits{client: redisClient()}
+	}
+	return newMemoryLimits()
+}
+
+// rateLimiter limits login attempts per client address and per email, backing off after failed attempts,
+// and api requests per user. Limits are not enforced while the store is unreachable, as logging in matters
+// more than limiting it for a moment.
+type rateLimiter struct {
+	store  limitStore
+	limits config.RateLimitConfig
+	logger logger.Logger
+}
+
+type limitKey struct {
+	name  string
+	limit int
+}
+
+// loginKeys returns the keys a login attempt counts against. Emails are hashed so the store holds no addresses.
+func (l *rateLimiter) loginKeys(c *gin.Context) []limitKey {
+	keys := []limitKey{{name: "ip:" + c.ClientIP(), limit: l.limits.LoginPerIP}}
+	if email := strings.ToLower(strings.TrimSpace(c.PostForm("email"))); email != "" {
+		sum := sha256.Sum256([]byte(email))
+		keys = append(keys, limitKey{name: "email:" + hex.EncodeToString(sum[:16]), limit: l.limits.LoginPerEmail})
+	}
+	return keys
+}
+
+// limitLogins refuses login attempts of blocked or too active addresses and emails, and blocks them after
+// a failed attempt. A successful login lifts the block of the email, but not of the address, so one valid
+// account does not let an address try others.
+func (l *rateLimiter) limitLogins(c *gin.Context) {
+	ctx := c.Request.Context()
+	keys := l.loginKeys(c)
+	for _, key := range keys {
+		wait, err := l.store.ttl(ctx, limitPrefix+"block:"+key.name)
+		if err != nil {
+			l.storeFailed(ctx, err)
+			c.Next()
+			return
+		}
+		if wait > 0 {
+			l.reject(c, "login", wait)
+			return
+		}
+	}
+	for _, key := range keys {
+		count, reset, err := l.store.incr(ctx, limitPrefix+"login:"+key.name, l.limits.LoginWindow)
+		if err != nil {
+			l.storeFailed(ctx, err)
+			c.Next()
+			return
+		}
+		if count > int64(key.limit) {
+			l.reject(c, "login", reset)
+			return
+		}
+	}
+
+	c.Next()
+
+	switch c.Writer.Status() {
+	case http.StatusOK:
+		if len(keys) > 1 {
+			email := keys[1].name
+			if err := l.store.del(ctx, limitPrefix+"failures:"+email, limitPrefix+"block:"+email); err != nil {
+				l.storeFailed(ctx, err)
+			}
+		}
+	case http.StatusUnauthorized:
+		for _, key := range keys {
+			failures, _, err := l.store.incr(ctx, limitPrefix+"failures:"+key.name, l.limits.LockoutDuration)
+			if err == nil {
+				err = l.store.set(ctx, limitPrefix+"block:"+key.name, l.backoff(failures))
+			}
+			if err != nil {
+				l.storeFailed(ctx, err)
+				return
+			}
+		}
+	}
+}
+
+// backoff returns how long a key is blocked after its given number of failed logins: FailureBackoff doubled
+// for every earlier failure, and LockoutDuration from LockoutAfter failures on.
+func (l *rateLimiter) backoff(failures int64) time.Duration {
+	if failures >= int64(l.limits.LockoutAfter) {
+		return l.limits.LockoutDuration
+	}
+	backoff := l.limits.FailureBackoff
+	for i := int64(1); i < failures && backoff < l.limits.LockoutDuration; i++ {
+		backoff *= 2
+	}
+	if backoff > l.limits.LockoutDuration {
+		return l.limits.LockoutDuration
+	}
+	return backoff
+}
+
+// limitApi refuses api requests of users that exceed their limit. Must run after jwtauth.Middleware.
+func (l *rateLimiter) limitApi(c *gin.Context) {
+	ctx := c.Request.Context()
+	claims, _ := jwtauth.ClaimsFromContext(ctx)
+	count, reset, err := l.store.incr(ctx, limitPrefix+"api:"+claims.Subject, l.limits.ApiWindow)
+	if err != nil {
+		l.storeFailed(ctx, err)
+	} else if count > int64(l.limits.ApiRequests) {
+		l.reject(c, "api", reset)
+		return
+	}
+	c.Next()
+}
+
+func (l *rateLimiter) reject(c *gin.Context, route string, retryAfter time.Duration) {
+	rateLimited.WithLabelValues(route).Inc()
+	seconds := int((retryAfter + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"code":    http.StatusTooManyRequests,
+		"message": "too many requests",
+	})
+}
+
+func (l *rateLimiter) storeFailed(ctx context.Context, err error) {
+	logger.ForContext(l.logger, ctx).Errorw("Failed to apply rate limit", "error", err)
+}
+
+// redisLimits shares the limits between gateway replicas.
+type redisLimits struct {
+	client *redis.Client
+}
+
+// incrScript increments the counter and starts its window if it has none, in one step, so a counter is never
+// left without expiry. It returns the count and the milliseconds left in the window.
+var incrScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl < 0 then
+	ttl = tonumber(ARGV[1])
+	redis.call("PEXPIRE", KEYS[1], ttl)
+end
+return {count, ttl}
+`)
+
+func (r *redisLimits) incr(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error) {
+	result, err := incrScript.Run(ctx, r.client, []string{key}, window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(result) != 2 {
+		return 0, 0, fmt.Errorf("unexpected rate limit script result %v", result)
+	}
+	return result[0], time.Duration(result[1]) * time.Millisecond, nil
+}
+
+func (r *redisLimits) set(ctx context.Context, key string, ttl time.Duration) error {
+	return r.client.Set(ctx, key, 1, ttl).Err()
+}
+
+func (r *redisLimits) ttl(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := r.client.PTTL(ctx, key).Result()
+	if err != nil || ttl < 0 {
+		return 0, err
+	}
+	return ttl, nil
+}
+
+func (r *redisLimits) del(ctx context.Context, keys ...string) error {
+	return r.client.Del(ctx, keys...).Err()
+}
+
+func (r *redisLimits) ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+// memoryLimits keeps the limits in the gateway process.
+type memoryLimits struct {
+	mutex   sync.Mutex
+	entries map[string]limitEntry
+	pruned  time.Time
+}
+
+type limitEntry struct {
+	count  int64
+	expire time.Time
+}
+
+func newMemoryLimits() *memoryLimits {
+	return &memoryLimits{
+		entries: make(map[string]limitEntry),
+	}
+}
+
+func (m *memoryLimits) incr(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now()
+	entry, found := m.entries[key]
+	if !found || !now.Before(entry.expire) {
+		m.prune(now)
+		entry = limitEntry{expire: now.Add(window)}
+	}
+	entry.count++
+	m.entries[key] = entry
+	return entry.count, entry.expire.Sub(now), nil
+}
+
+func (m *memoryLimits) set(ctx context.Context, key string, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.entries[key] = limitEntry{count: 1, expire: time.Now().Add(ttl)}
+	return nil
+}
+
+func (m *memoryLimits) ttl(ctx context.Context, key string) (time.Duration, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	entry, found := m.entries[key]
+	if !found {
+		return 0, nil
+	}
+	if ttl := time.Until(entry.expire); ttl > 0 {
+		return ttl, nil
+	}
+	return 0, nil
+}
+
+func (m *memoryLimits) del(ctx context.Context, keys ...string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, key := range keys {
+		delete(m.entries, key)
+	}
+	return nil
+}
+
+func (m *memoryLimits) ping(ctx context.Context) error {
+	return nil
+}
+
+// prune drops expired entries, at most once a minute. The mutex must be held.
+func (m *memoryLimits) prune(now time.Time) {
+	if now.Sub(m.pruned) < time.Minute {
+		return
+	}
+	m.pruned = now
+	for key, entry := range m.entries {
+		if !now.Before(entry.expire) {
+			delete(m.entries, key)
+		}
+	}
+}
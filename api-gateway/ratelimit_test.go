@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+)
+
+func TestLimitStoreIncr(t *testing.T) {
+	stores := []struct {
+		name  string
+		store func(t *testing.T) limitStore
+	}{
+		{"memory", func(t *testing.T) limitStore { return newMemoryLimits() }},
+		{"redis", func(t *testing.T) limitStore { return &redisLimits{client: testRedis(t)} }},
+	}
+	const window = 200 * time.Millisecond
+	// Hits against one key, each after the given wait.
+	steps := []struct {
+		name  string
+		wait  time.Duration
+		count int64
+	}{
+		{"first hit starts the window", 0, 1},
+		{"later hits count in the window", 50 * time.Millisecond, 2},
+		{"hits do not extend the window", 50 * time.Millisecond, 3},
+		{"first hit after the window starts a new one", window, 1},
+		{"hits count in the new window", 0, 2},
+	}
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			store := s.store(t)
+			ctx := context.Background()
+			key := limitPrefix + "test:" + strconv.FormatInt(time.Now().UnixNano(), 10)
+			defer store.del(ctx, key)
+
+			var windowEnd time.Time
+			for _, step := range steps {
+				time.Sleep(step.wait)
+				count, reset, err := store.incr(ctx, key, window)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if count != step.count {
+					t.Errorf("%s: count = %d, want %d", step.name, count, step.count)
+				}
+				if reset <= 0 || reset > window {
+					t.Errorf("%s: reset in %v, want within %v", step.name, reset, window)
+				}
+				end := time.Now().Add(reset)
+				if count > 1 && end.Sub(windowEnd).Abs() > 20*time.Millisecond {
+					t.Errorf("%s: window ends at %v, want %v", step.name, end, windowEnd)
+				}
+				if count == 1 {
+					windowEnd = end
+				}
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	limiter := &rateLimiter{limits: config.RateLimitConfig{
+		FailureBackoff:  time.Second,
+		LockoutAfter:    5,
+		LockoutDuration: 10 * time.Second,
+	}}
+	tests := []struct {
+		failures int64
+		backoff  time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{9, 10 * time.Second},
+	}
+	for _, test := range tests {
+		if backoff := limiter.backoff(test.failures); backoff != test.backoff {
+			t.Errorf("backoff(%d) = %v, want %v", test.failures, backoff, test.backoff)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+)
+
+// sharedRedis returns a function that connects to Redis on its first call, so the stores that keep their
+// state in Redis share one connection pool, and none is opened if every store keeps its state in memory.
+func sharedRedis(cfg config.RedisConfig) func() *redis.Client {
+	var once sync.Once
+	var client *redis.Client
+	return func() *redis.Client {
+		once.Do(func() {
+			client = redis.NewClient(&redis.Options{
+				Addr:     cfg.Addr,
+				Password: cfg.Password,
+				DB:       cfg.DB,
+			})
+		})
+		return client
+	}
+}
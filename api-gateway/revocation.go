@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+	"github.com/Kwintenvdb/unity-publisher-management/common/jwtauth"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+)
+
+// revocationStore remembers the tokens logged out before they expire.
+// Single tokens are revoked by token id, every token of a user by the time the user logged out everywhere.
+type revocationStore interface {
+	// revokeToken revokes the token until it expires. It reports false if the token was already revoked.
+	revokeToken(ctx context.Context, id string, expire time.Time) (bool, error)
+	// revokeUser revokes every token issued to the subject at or before the given time.
+	// The revocation is kept for ttl, after which those tokens have expired.
+	revokeUser(ctx context.Context, subject string, before time.Time, ttl time.Duration) error
+	revoked(ctx context.Context, claims *jwtauth.Claims) (bool, error)
+	ping(ctx context.Context) error
+}
+
+func newRevocationStore(cfg *config.Config, redisClient func() *redis.Client) revocationStore {
+	if cfg.Gateway.RevocationStore == "redis" {
+		return &redisRevocations{client: redisClient()}
+	}
+	return newMemoryRevocations()
+}
+
+// rejectRevoked rejects tokens that were logged out. Must run after jwtauth.Middleware.
+// Requests are refused while the store is unreachable rather than letting revoked tokens through.
+func rejectRevoked(store revocationStore, log logger.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		claims, ok := jwtauth.ClaimsFromContext(ctx)
+		if !ok {
+			unauthorized(c, "missing token")
+			return
+		}
+		revoked, err := store.revoked(ctx, claims)
+		if err != nil {
+			logger.ForContext(log, ctx).Errorw("Failed to check token revocation", "error", err)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"code":    http.StatusServiceUnavailable,
+				"message": "failed to check token",
+			})
+			return
+		}
+		if revoked {
+			unauthorized(c, "token has been revoked")
+			return
+		}
+		c.Next()
+	}
+}
+
+// issuedAt returns the issue time of the token in Unix milliseconds. Tokens are issued with millisecond
+// precision, so revoking the tokens of a user spares those of a login in the same second.
+func issuedAt(claims *jwtauth.Claims) int64 {
+	if claims.IssuedAt == nil {
+		return 0
+	}
+	return claims.IssuedAt.UnixMilli()
+}
+
+const (
+	redisTokenPrefix = "upm:revoked:token:"
+	redisUserPrefix  = "upm:revoked:user:"
+)
+
+// redisRevocations shares revocations between gateway replicas. Keys expire along with the tokens they revoke.
+type redisRevocations struct {
+	client *redis.Client
+}
+
+func (r *redisRevocations) revokeToken(ctx context.Context, id string, expire time.Time) (bool, error) {
+	ttl := time.Until(expire)
+	if ttl <= 0 {
+		return true, nil
+	}
+	return r.client.SetNX(ctx, redisTokenPrefix+id, 1, ttl).Result()
+}
+
+func (r *redisRevocations) revokeUser(ctx context.Context, subject string, before time.Time, ttl time.Duration) error {
+	return r.client.Set(ctx, redisUserPrefix+subject, before.UnixMilli(), ttl).Err()
+}
+
+func (r *redisRevocations) revoked(ctx context.Context, claims *jwtauth.Claims) (bool, error) {
+	values, err := r.client.MGet(ctx, redisTokenPrefix+claims.ID, redisUserPrefix+claims.Subject).Result()
+	if err != nil {
+		return false, err
+	}
+	if claims.ID != "" && values[0] != nil {
+		return true, nil
+	}
+	if value, ok := values[1].(string); ok {
+		before, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return false, err
+		}
+		return issuedAt(claims) <= before, nil
+	}
+	return false, nil
+}
+
+func (r *redisRevocations) ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+// memoryRevocations keeps revocations in the gateway process.
+type memoryRevocations struct {
+	mutex  sync.Mutex
+	tokens map[string]time.Time
+	users  map[string]userRevocation
+}
+
+type userRevocation struct {
+	before time.Time
+	expire time.Time
+}
+
+func newMemoryRevocations() *memoryRevocations {
+	return &memoryRevocations{
+		tokens: make(map[string]time.Time),
+		users:  make(map[string]userRevocation),
+	}
+}
+
+func (m *memoryRevocations) revokeToken(ctx context.Context, id string, expire time.Time) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.prune(time.Now())
+	if _, found := m.tokens[id]; found {
+		return false, nil
+	}
+	m.tokens[id] = expire
+	return true, nil
+}
+
+func (m *memoryRevocations) revokeUser(ctx context.Context, subject string, before time.Time, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.prune(time.Now())
+	m.users[subject] = userRevocation{before: before, expire: time.Now().Add(ttl)}
+	return nil
+}
+
+func (m *memoryRevocations) revoked(ctx context.Context, claims *jwtauth.Claims) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now()
+	if expire, found := m.tokens[claims.ID]; found && claims.ID != "" && now.Before(expire) {
+		return true, nil
+	}
+	if user, found := m.users[claims.Subject]; found && now.Before(user.expire) {
+		return issuedAt(claims) <= user.before.UnixMilli(), nil
+	}
+	return false, nil
+}
+
+func (m *memoryRevocations) ping(ctx context.Context) error {
+	return nil
+}
+
+// prune drops the revocations of tokens that have expired anyway. The mutex must be held.
+func (m *memoryRevocations) prune(now time.Time) {
+	for id, expire := range m.tokens {
+		if !now.Before(expire) {
+			delete(m.tokens, id)
+		}
+	}
+	for subject, user := range m.users {
+		if !now.Before(user.expire) {
+			delete(m.users, subject)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	gojwt "github.com/golang-jwt/jwt/v4"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/jwtauth"
+)
+
+// testRedis returns a client of the Redis at UPM_TEST_REDIS_ADDR, and skips the test if none is given.
+func testRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	addr := os.Getenv("UPM_TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("UPM_TEST_REDIS_ADDR is not set")
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestRevocations(t *testing.T) {
+	stores := []struct {
+		name  string
+		store func(t *testing.T) revocationStore
+	}{
+		{"memory", func(t *testing.T) revocationStore { return newMemoryRevocations() }},
+		{"redis", func(t *testing.T) revocationStore { return &redisRevocations{client: testRedis(t)} }},
+	}
+	// Halfway through a second, so tokens of the same second are issued on either side of the logout.
+	loggedOut := time.Now().Truncate(time.Second).Add(500 * time.Millisecond)
+	tests := []struct {
+		name    string
+		issued  *time.Time
+		subject string
+		revoked bool
+	}{
+		{"issued earlier", timeAt(loggedOut.Add(-time.Hour)), "user", true},
+		{"issued earlier in the second", timeAt(loggedOut.Add(-time.Millisecond)), "user", true},
+		{"issued at logout", timeAt(loggedOut), "user", true},
+		{"issued later in the second", timeAt(loggedOut.Add(time.Millisecond)), "user", false},
+		{"issued later", timeAt(loggedOut.Add(time.Hour)), "user", false},
+		{"without issue time", nil, "user", true},
+		{"other user", timeAt(loggedOut.Add(-time.Hour)), "other", false},
+	}
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			store := s.store(t)
+			ctx := context.Background()
+			// Subjects of their own, so runs against the same Redis do not see each other's revocations.
+			run := strconv.FormatInt(time.Now().UnixNano(), 10)
+			if err := store.revokeUser(ctx, "user-"+run, loggedOut, time.Minute); err != nil {
+				t.Fatal(err)
+			}
+			for _, test := range tests {
+				t.Run(test.name, func(t *testing.T) {
+					claims := &jwtauth.Claims{RegisteredClaims: gojwt.RegisteredClaims{ID: "token-" + run, Subject: test.subject + "-" + run}}
+					if test.issued != nil {
+						claims.IssuedAt = gojwt.NewNumericDate(*test.issued)
+					}
+					revoked, err := store.revoked(ctx, claims)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if revoked != test.revoked {
+						t.Errorf("revoked = %v, want %v", revoked, test.revoked)
+					}
+				})
+			}
+		})
+	}
+}
+
+func timeAt(t time.Time) *time.Time {
+	return &t
+}
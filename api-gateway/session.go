@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Kwintenvdb/unity-publisher-management/api-gateway/auth"
+	"github.com/Kwintenvdb/unity-publisher-management/common/events"
+	"github.com/Kwintenvdb/unity-publisher-management/common/jwtauth"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+	"github.com/Kwintenvdb/unity-publisher-management/common/session"
+)
+
+// sessionExpiryWriter calls expired before the headers of a response reporting an expired Unity session are sent.
+type sessionExpiryWriter struct {
+	gin.ResponseWriter
+	expired func()
+	checked bool
+}
+
+func (w *sessionExpiryWriter) WriteHeader(code int) {
+	if !w.checked {
+		w.checked = true
+		if code == http.StatusUnauthorized && w.Header().Get(session.ExpiredHeader) != "" {
+			w.expired()
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// invalidateExpiredSessions logs the user out once the api-service reports that Unity rejected the kharma session.
+// The token and kharma cookies are cleared and the scheduler is told to stop caching sales for the session.
+func invalidateExpiredSessions(issuer *tokenIssuer, producer *auth.Producer, log logger.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer = &sessionExpiryWriter{
+			ResponseWriter: c.Writer,
+			expired: func() {
+				issuer.clearCookies(c)
+
+				ctx := c.Request.Context()
+				requestLog := logger.ForContext(log, ctx)
+				claims, ok := jwtauth.ClaimsFromContext(ctx)
+				if !ok {
+					return
+				}
+				kharmaSession, _ := c.Cookie("kharma_session")
+				requestLog.Infow("Unity session expired", "publisher", claims.Publisher)
+				if err := producer.SendSessionEndedMessage(ctx, claims.Publisher, session.Fingerprint(kharmaSession), events.ReasonExpired); err != nil {
+					requestLog.Errorw("Failed to publish session expiry", "error", err, "publisher", claims.Publisher)
+				}
+			},
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The frontend build is copied into web/dist before building the gateway to embed it.
+//
+//go:embed all:web/dist
+var embeddedAssets embed.FS
+
+const indexFile = "index.html"
+
+// hashedAsset matches file names carrying a content hash of at least 8 characters, like index-4f8a2c1d.js
+// or app.4f8a2c1d.css. Their content never changes, so they are cached for a year.
+var hashedAsset = regexp.MustCompile(`[.-]([0-9A-Za-z_]{8,})\.[0-9a-z]+$`)
+var digit = regexp.MustCompile(`[0-9]`)
+
+// precompressed lists the encodings of the variants the frontend build may ship next to each file,
+// in order of preference, with their file extension.
+var precompressed = []struct {
+	encoding  string
+	extension string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// staticAssets serves the frontend single page application.
+type staticAssets struct {
+	files fs.FS
+	csp   string
+
+	// Files served from a directory can change, so their tags are only remembered for the embedded build.
+	cacheETags bool
+	mutex      sync.Mutex
+	etags      map[string]string
+}
+
+// newStaticAssets serves the frontend from the directory, or from the embedded build if the directory is empty.
+// It returns nil if there is no frontend to serve.
+func newStaticAssets(dir, csp string) (*staticAssets, error) {
+	var files fs.FS
+	if dir != "" {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, errors.New(dir + " is not a directory")
+		}
+		files = os.DirFS(dir)
+	} else {
+		sub, err := fs.Sub(embeddedAssets, "web/dist")
+		if err != nil {
+			return nil, err
+		}
+		files = sub
+	}
+
+	if _, err := fs.Stat(files, indexFile); err != nil {
+		if dir != "" {
+			return nil, err
+		}
+		return nil, nil
+	}
+	return &staticAssets{
+		files:      files,
+		csp:        csp,
+		cacheETags: dir == "",
+		etags:      make(map[string]string),
+	}, nil
+}
+
+// handler serves the file at the request path. Paths without a file extension that match no file are routes
+// of the application, and get index.html so the client side router can render them.
+func (s *staticAssets) handler(c *gin.Context) {
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		c.String(http.StatusNotFound, "Not found")
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+c.Request.URL.Path), "/")
+	if name == "" {
+		name = indexFile
+	}
+	if !s.isFile(name) {
+		if path.Ext(name) != "" || hidden(name) {
+			c.String(http.StatusNotFound, "Not found")
+			return
+		}
+		name = indexFile
+	}
+	s.serve(c, name)
+}
+
+// hashed reports whether the file name carries a content hash. Hashes contain a digit, which tells them
+// apart from names like vendor-polyfills.js.
+func hashed(name string) bool {
+	match := hashedAsset.FindStringSubmatch(path.Base(name))
+	return match != nil && digit.MatchString(match[1])
+}
+
+// hidden reports whether the path names a dot file, such as .gitignore, which are never served.
+func hidden(name string) bool {
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *staticAssets) isFile(name string) bool {
+	if hidden(name) || !fs.ValidPath(name) {
+		return false
+	}
+	info, err := fs.Stat(s.files, name)
+	return err == nil && !info.IsDir()
+}
+
+func (s *staticAssets) serve(c *gin.Context, name string) {
+	header := c.Writer.Header()
+	header.Set("Content-Security-Policy", s.csp)
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Referrer-Policy", "same-origin")
+	if hashed(name) {
+		header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		header.Set("Cache-Control", "no-cache")
+	}
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	// Serve a precompressed variant if the client accepts it. The content type stays that of the original file.
+	file := name
+	header.Add("Vary", "Accept-Encoding")
+	acceptEncoding := c.GetHeader("Accept-Encoding")
+	for _, variant := range precompressed {
+		if acceptsEncoding(acceptEncoding, variant.encoding) && s.isFile(name+variant.extension) {
+			file = name + variant.extension
+			header.Set("Content-Encoding", variant.encoding)
+			break
+		}
+	}
+
+	content, err := fs.ReadFile(s.files, file)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to read file")
+		return
+	}
+	header.Set("ETag", s.etag(file, content))
+	http.ServeContent(c.Writer, c.Request, name, time.Time{}, bytes.NewReader(content))
+}
+
+// etag returns a strong validator of the file, so unhashed files are revalidated rather than downloaded again.
+func (s *staticAssets) etag(file string, content []byte) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if etag, ok := s.etags[file]; ok {
+		return etag
+	}
+	sum := sha256.Sum256(content)
+	etag := strconv.Quote(hex.EncodeToString(sum[:8]))
+	if s.cacheETags {
+		s.etags[file] = etag
+	}
+	return etag
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header allows the encoding, honouring q=0.
+func acceptsEncoding(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), encoding) {
+			continue
+		}
+		params = strings.ReplaceAll(params, " ", "")
+		if strings.HasPrefix(params, "q=") {
+			value, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64)
+			return err == nil && value > 0
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gojwt "github.com/golang-jwt/jwt/v4"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/browser"
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+	"github.com/Kwintenvdb/unity-publisher-management/common/jwtauth"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+)
+
+const (
+	refreshCookie        = "refresh_token"
+	serviceTokenLifetime = 5 * time.Minute
+)
+
+func init() {
+	// Tokens carry their issue time in milliseconds, see issuedAt.
+	gojwt.TimePrecision = time.Millisecond
+}
+
+type tokenPair struct {
+	access        string
+	accessExpire  time.Time
+	refresh       string
+	refreshExpire time.Time
+}
+
+// tokenIssuer issues short-lived access tokens, which every service accepts, and refresh tokens,
+// which only the gateway accepts to renew the access token until the login expires.
+// Refresh tokens are told apart by their audience, the gateway itself. Every token carries a unique id,
+// so it can be revoked, and refresh tokens are revoked once they are exchanged.
+type tokenIssuer struct {
+	keys            *keyRing
+	issuer          string
+	audience        string
+	accessLifetime  time.Duration
+	refreshLifetime time.Duration
+	refreshVerifier *jwtauth.Verifier
+	revocations     revocationStore
+	cookies         config.BrowserConfig
+	logger          logger.Logger
+}
+
+func newTokenIssuer(keys *keyRing, revocations revocationStore, cfg *config.Config, log logger.Logger) *tokenIssuer {
+	return &tokenIssuer{
+		keys:            keys,
+		issuer:          cfg.Auth.Issuer,
+		audience:        cfg.Auth.Audience,
+		accessLifetime:  cfg.Gateway.AccessTokenLifetime,
+		refreshLifetime: cfg.Gateway.RefreshTokenLifetime,
+		refreshVerifier: jwtauth.NewVerifier(keys, cfg.Auth.Issuer, cfg.Auth.Issuer),
+		revocations:     revocations,
+		cookies:         cfg.Browser,
+		logger:          log,
+	}
+}
+
+// issue signs the tokens of a login. A zero loginExpire starts a new login, otherwise the
+// refreshed tokens keep the expiry of the login they renew.
+func (i *tokenIssuer) issue(u *user, loginExpire time.Time) (*tokenPair, error) {
+	now := time.Now()
+	if loginExpire.IsZero() {
+		loginExpire = now.Add(i.refreshLifetime)
+	}
+	accessExpire := now.Add(i.accessLifetime)
+	if accessExpire.After(loginExpire) {
+		accessExpire = loginExpire
+	}
+
+	accessClaims, err := i.claims(u, i.audience, now, accessExpire)
+	if err != nil {
+		return nil, err
+	}
+	access, err := i.keys.sign(accessClaims)
+	if err != nil {
+		return nil, err
+	}
+	refreshClaims, err := i.claims(u, i.issuer, now, loginExpire)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := i.keys.sign(refreshClaims)
+	if err != nil {
+		return nil, err
+	}
+	return &tokenPair{
+		access:        access,
+		accessExpire:  accessExpire,
+		refresh:       refresh,
+		refreshExpire: loginExpire,
+	}, nil
+}
+
+func (i *tokenIssuer) claims(u *user, audience string, now, expire time.Time) (jwtauth.Claims, error) {
+	id, err := newTokenID()
+	if err != nil {
+		return jwtauth.Claims{}, err
+	}
+	return jwtauth.Claims{
+		RegisteredClaims: gojwt.RegisteredClaims{
+			ID:        id,
+			Issuer:    i.issuer,
+			Subject:   u.Email,
+			Audience:  gojwt.ClaimStrings{audience},
+			IssuedAt:  gojwt.NewNumericDate(now),
+			ExpiresAt: gojwt.NewNumericDate(expire),
+		},
+		Email:     u.Email,
+		Publisher: u.PublisherId,
+	}, nil
+}
+
+// serviceToken identifies the gateway itself to another service. It is signed like user tokens,
+// so services verify it against the same published keys, but carries the service as audience.
+func (i *tokenIssuer) serviceToken(audience config.Service) (string, error) {
+	now := time.Now()
+	return i.keys.sign(jwtauth.Claims{
+		RegisteredClaims: gojwt.RegisteredClaims{
+			Issuer:    i.issuer,
+			Subject:   string(config.Gateway),
+			Audience:  gojwt.ClaimStrings{string(audience)},
+			IssuedAt:  gojwt.NewNumericDate(now),
+			ExpiresAt: gojwt.NewNumericDate(now.Add(serviceTokenLifetime)),
+		},
+	})
+}
+
+func newTokenID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+func (i *tokenIssuer) setCookies(c *gin.Context, pair *tokenPair) {
+	for _, cookie := range i.tokenCookies(pair) {
+		http.SetCookie(c.Writer, cookie)
+	}
+}
+
+// tokenCookies returns the cookies that hold the tokens of the pair.
+func (i *tokenIssuer) tokenCookies(pair *tokenPair) []*http.Cookie {
+	return []*http.Cookie{
+		browser.Cookie(i.cookies, jwtauth.CookieName, pair.access, int(time.Until(pair.accessExpire).Seconds())),
+		browser.Cookie(i.cookies, refreshCookie, pair.refresh, int(time.Until(pair.refreshExpire).Seconds())),
+	}
+}
+
+// clearCookies logs the browser out of the gateway and of the Unity session.
+func (i *tokenIssuer) clearCookies(c *gin.Context) {
+	for _, name := range []string{jwtauth.CookieName, refreshCookie, "kharma_token", "kharma_session"} {
+		browser.SetCookie(c.Writer, i.cookies, name, "", -1)
+	}
+}
+
+// refreshHandler exchanges a refresh token, from the refresh_token cookie or form field, for new tokens.
+// Each refresh token is accepted once; replaying it fails.
+func (i *tokenIssuer) refreshHandler(c *gin.Context) {
+	token := refreshTokenFromRequest(c)
+	if token == "" {
+		unauthorized(c, "missing refresh token")
+		return
+	}
+
+	ctx := c.Request.Context()
+	// Tokens without an id cannot be revoked once used, so they must log in again.
+	claims, err := i.refreshVerifier.Verify(ctx, token)
+	if err != nil || claims.ID == "" {
+		unauthorized(c, "invalid refresh token")
+		return
+	}
+	revoked, err := i.revocations.revoked(ctx, claims)
+	if err == nil && !revoked {
+		var fresh bool
+		fresh, err = i.revocations.revokeToken(ctx, claims.ID, claims.ExpiresAt.Time)
+		revoked = !fresh
+	}
+	if err != nil {
+		logger.ForContext(i.logger, ctx).Errorw("Failed to revoke refresh token", "error", err)
+		c.String(http.StatusServiceUnavailable, "Failed to refresh token")
+		return
+	}
+	if revoked {
+		unauthorized(c, "refresh token has been revoked")
+		return
+	}
+
+	u := &user{Email: claims.Email, PublisherId: claims.Publisher}
+	pair, err := i.issue(u, claims.ExpiresAt.Time)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to create token")
+		return
+	}
+	i.setCookies(c, pair)
+	c.JSON(http.StatusOK, loginResponse(u, pair))
+}
+
+func refreshTokenFromRequest(c *gin.Context) string {
+	if token, err := c.Cookie(refreshCookie); err == nil && token != "" {
+		return token
+	}
+	return c.PostForm(refreshCookie)
+}
+
+func loginResponse(u *user, pair *tokenPair) gin.H {
+	return gin.H{
+		"email":         u.Email,
+		"publisherId":   u.PublisherId,
+		"token":         pair.access,
+		"expire":        pair.accessExpire.Format(time.RFC3339),
+		"refreshToken":  pair.refresh,
+		"refreshExpire": pair.refreshExpire.Format(time.RFC3339),
+	}
+}
+
+func unauthorized(c *gin.Context, message string) {
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+		"code":    http.StatusUnauthorized,
+		"message": message,
+	})
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/tracing"
+)
+
+var tracer = tracing.Tracer("github.com/Kwintenvdb/unity-publisher-management/api-gateway")
+
+// httpClient propagates the trace context to the services the gateway calls.
+var httpClient = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
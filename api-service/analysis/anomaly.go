@@ -0,0 +1,146 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/Kwintenvdb/unity-publisher-management/api/model"
+)
+
+// Months of history that make up the rolling baseline.
+const rollingWindow = 6
+
+// Fewer months than this is not enough to tell what normal looks like.
+const minBaselineMonths = 3
+
+// Movements beyond this many standard deviations are flagged.
+const anomalyThreshold = 3.0
+
+type AnomalyKind string
+
+const (
+	Spike AnomalyKind = "spike"
+	Drop  AnomalyKind = "drop"
+)
+
+type Anomaly struct {
+	PackageId   string      `json:"package_id"`
+	PackageName string      `json:"package_name"`
+	Month       string      `json:"month"`
+	Kind        AnomalyKind `json:"kind"`
+	UnitsToDate int         `json:"units_to_date"`
+	Projected   float64     `json:"projected_units"`
+	Baseline    float64     `json:"baseline_units"`
+	ZScore      float64     `json:"z_score"`
+}
+
+// DetectAnomalies compares the sales of each package in the given month against the mean and
+// standard deviation of its previous months. An in-progress month is projected to month end at its
+// run rate, and the extra noise of that projection is accounted for, so a package selling nothing
+// for a few days is only flagged once that is genuinely unusual.
+func DetectAnomalies(month string, current []model.SalesData, history History, now time.Time) ([]Anomaly, error) {
+	period, err := ParsePeriod(month)
+	if err != nil {
+		return nil, err
+	}
+
+	progress := 1.0
+	if period == PeriodOf(now) {
+		progress = math.Max(now.Sub(period.Start()).Hours()/24, 0.5) / float64(period.Days())
+	}
+
+	series := history.Series()
+	rows := make(map[string]model.SalesData)
+	for _, s := range mergeRows(current) {
+		rows[PackageKey(s)] = s
+	}
+
+	// Packages without any sales this month do not show up in the Unity report at all,
+	// so every package with history is checked.
+	for key := range series {
+		if _, ok := rows[key]; !ok {
+			rows[key] = lastKnownRow(history, key)
+		}
+	}
+
+	var anomalies []Anomaly
+	for key, s := range rows {
+		baseline, ok := rollingBaseline(series[key], period)
+		if !ok {
+			continue
+		}
+		mean, sd := meanAndDeviation(baseline)
+
+		units := unitsIn(current, key)
+		projected := float64(units) / progress
+
+		// Poisson noise of the projection grows as the observed part of the month shrinks.
+		deviation := math.Sqrt(sd*sd + mean/progress)
+		if deviation == 0 {
+			continue
+		}
+		z := (projected - mean) / deviation
+		if math.Abs(z) < anomalyThreshold {
+			continue
+		}
+
+		kind := Spike
+		if z < 0 {
+			kind = Drop
+		}
+		anomalies = append(anomalies, Anomaly{
+			PackageId:   s.PackageId,
+			PackageName: s.PackageName,
+			Month:       month,
+			Kind:        kind,
+			UnitsToDate: units,
+			Projected:   projected,
+			Baseline:    mean,
+			ZScore:      z,
+		})
+	}
+
+	sort.Slice(anomalies, func(i, j int) bool {
+		return math.Abs(anomalies[i].ZScore) > math.Abs(anomalies[j].ZScore)
+	})
+	return anomalies, nil
+}
+
+// rollingBaseline returns the units of the months preceding the period.
+// Months without a report for the package count as zero once the package has sold before.
+func rollingBaseline(past map[Period]Amount, period Period) ([]float64, bool) {
+	var baseline []float64
+	seen := false
+	for i := rollingWindow; i >= 1; i-- {
+		amount, ok := past[period.AddMonths(-i)]
+		if ok {
+			seen = true
+		}
+		if seen {
+			baseline = append(baseline, float64(amount.Units))
+		}
+	}
+	return baseline, len(baseline) >= minBaselineMonths
+}
+
+func unitsIn(sales []model.SalesData, key string) int {
+	units := 0
+	for _, s := range sales {
+		if PackageKey(s) == key {
+			units += s.Sales
+		}
+	}
+	return units
+}
+
+func lastKnownRow(history History, key string) model.SalesData {
+	for i := len(history) - 1; i >= 0; i-- {
+		for _, s := range history[i].Sales {
+			if PackageKey(s) == key {
+				return model.SalesData{PackageId: s.PackageId, PackageName: s.PackageName}
+			}
+		}
+	}
+	return model.SalesData{}
+}
@@ -0,0 +1,233 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/Kwintenvdb/unity-publisher-management/api/model"
+)
+
+// z-score of the two-sided 95% confidence interval.
+const confidenceZ = 1.96
+
+// Months of history used as the baseline for the seasonal expectation.
+const baselineMonths = 12
+
+type Band struct {
+	Low      float64 `json:"low"`
+	Expected float64 `json:"expected"`
+	High     float64 `json:"high"`
+}
+
+type PackageForecast struct {
+	PackageId   string  `json:"package_id"`
+	PackageName string  `json:"package_name"`
+	UnitsToDate int     `json:"units_to_date"`
+	GrossToDate float64 `json:"gross_to_date"`
+	Units       Band    `json:"units"`
+	Gross       Band    `json:"gross"`
+}
+
+type TotalForecast struct {
+	UnitsToDate int     `json:"units_to_date"`
+	GrossToDate float64 `json:"gross_to_date"`
+	Units       Band    `json:"units"`
+	Gross       Band    `json:"gross"`
+}
+
+type Forecast struct {
+	Month       string            `json:"month"`
+	DaysElapsed float64           `json:"days_elapsed"`
+	DaysInMonth int               `json:"days_in_month"`
+	Packages    []PackageForecast `json:"packages"`
+	Total       TotalForecast     `json:"total"`
+}
+
+// ForecastMonth projects the month-end units and gross of the in-progress month.
+//
+// The projection blends the month-to-date run rate with a seasonal expectation: the average
+// of the preceding twelve months scaled by how the same calendar month performed relative to
+// its own preceding twelve months in earlier years. The run rate gains weight as the month
+// progresses. Confidence bands combine Poisson noise on the remaining units with the spread
+// of the seasonal indices. Packages without past months are projected at the run rate alone,
+// with the Poisson uncertainty of that rate in place of the seasonal spread.
+func ForecastMonth(month string, current []model.SalesData, history History, now time.Time) (Forecast, error) {
+	period, err := ParsePeriod(month)
+	if err != nil {
+		return Forecast{}, err
+	}
+
+	days := float64(period.Days())
+	elapsed := days
+	if period == PeriodOf(now) {
+		elapsed = math.Max(now.Sub(period.Start()).Hours()/24, 0.5)
+	}
+	progress := elapsed / days
+
+	series := history.Series()
+	forecast := Forecast{
+		Month:       month,
+		DaysElapsed: elapsed,
+		DaysInMonth: period.Days(),
+	}
+
+	var unitsVariance, grossVariance float64
+	for _, s := range mergeRows(current) {
+		key := PackageKey(s)
+		units := float64(s.Sales)
+		gross := ParseAmount(s.Gross)
+
+		past := series[key]
+		runRate := units / progress
+		blended, spread := runRate, runRateSpread(units)
+		if seasonal, seasonalSpread, ok := seasonalExpectation(past, period); ok {
+			blended = progress*runRate + (1-progress)*seasonal
+			spread = seasonalSpread
+		}
+		remaining := (1 - progress) * blended
+
+		sd := math.Sqrt(remaining + math.Pow(remaining*spread, 2))
+		price := unitPrice(s, past)
+
+		p := PackageForecast{
+			PackageId:   s.PackageId,
+			PackageName: s.PackageName,
+			UnitsToDate: s.Sales,
+			GrossToDate: gross,
+			Units:       band(units, units+remaining, sd),
+			Gross:       band(gross, gross+remaining*price, sd*price),
+		}
+		forecast.Packages = append(forecast.Packages, p)
+
+		forecast.Total.UnitsToDate += s.Sales
+		forecast.Total.GrossToDate += gross
+		forecast.Total.Units.Expected += p.Units.Expected
+		forecast.Total.Gross.Expected += p.Gross.Expected
+		unitsVariance += sd * sd
+		grossVariance += sd * sd * price * price
+	}
+
+	total := &forecast.Total
+	total.Units = band(float64(total.UnitsToDate), total.Units.Expected, math.Sqrt(unitsVariance))
+	total.Gross = band(total.GrossToDate, total.Gross.Expected, math.Sqrt(grossVariance))
+
+	sort.Slice(forecast.Packages, func(i, j int) bool {
+		return forecast.Packages[i].Units.Expected > forecast.Packages[j].Units.Expected
+	})
+	return forecast, nil
+}
+
+// seasonalExpectation returns the expected units of a package in the given period, and the
+// relative spread of that expectation across previous years. It returns false if there are no
+// months in the baseline to expect anything from.
+func seasonalExpectation(past map[Period]Amount, period Period) (float64, float64, bool) {
+	baseline, ok := averageUnits(past, period, baselineMonths)
+	if !ok {
+		return 0, 1, false
+	}
+
+	var indices []float64
+	for year := period.AddMonths(-12); ; year = year.AddMonths(-12) {
+		amount, found := past[year]
+		average, ok := averageUnits(past, year, baselineMonths)
+		if !found && !ok {
+			break
+		}
+		if found && ok && average > 0 {
+			indices = append(indices, float64(amount.Units)/average)
+		}
+	}
+	if len(indices) == 0 {
+		return baseline, 1, true
+	}
+
+	mean, sd := meanAndDeviation(indices)
+	if mean == 0 {
+		return 0, 1, true
+	}
+	return baseline * mean, sd / mean, true
+}
+
+// runRateSpread returns the relative spread of a run rate estimated from the given units to date,
+// which are Poisson distributed.
+func runRateSpread(units float64) float64 {
+	if units == 0 {
+		return 1
+	}
+	return 1 / math.Sqrt(units)
+}
+
+// averageUnits returns the mean monthly units in the n months before the given period.
+func averageUnits(past map[Period]Amount, period Period, n int) (float64, bool) {
+	var total, count float64
+	for i := 1; i <= n; i++ {
+		if amount, ok := past[period.AddMonths(-i)]; ok {
+			total += float64(amount.Units)
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, false
+	}
+	return total / count, true
+}
+
+func unitPrice(current model.SalesData, past map[Period]Amount) float64 {
+	if current.Sales > 0 {
+		return ParseAmount(current.Gross) / float64(current.Sales)
+	}
+
+	var units int
+	var gross float64
+	for _, amount := range past {
+		units += amount.Units
+		gross += amount.Gross
+	}
+	if units > 0 {
+		return gross / float64(units)
+	}
+	return ParseAmount(current.Price)
+}
+
+func band(toDate, expected, sd float64) Band {
+	return Band{
+		Low:      math.Max(toDate, expected-confidenceZ*sd),
+		Expected: expected,
+		High:     expected + confidenceZ*sd,
+	}
+}
+
+// mergeRows combines rows of the same package, which Unity reports separately when the price changed during the month.
+func mergeRows(sales []model.SalesData) []model.SalesData {
+	var merged []model.SalesData
+	index := make(map[string]int)
+	for _, s := range sales {
+		key := PackageKey(s)
+		if i, ok := index[key]; ok {
+			merged[i].Sales += s.Sales
+			merged[i].Gross = formatAmount(ParseAmount(merged[i].Gross) + ParseAmount(s.Gross))
+			continue
+		}
+		index[key] = len(merged)
+		merged = append(merged, s)
+	}
+	return merged
+}
+
+func meanAndDeviation(values []float64) (float64, float64) {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	if len(values) < 2 {
+		return mean, 0
+	}
+
+	var squares float64
+	for _, v := range values {
+		squares += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(squares / float64(len(values)-1))
+}
@@ -0,0 +1,175 @@
+package analysis
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Kwintenvdb/unity-publisher-management/api/model"
+)
+
+var june2023 = Period{Year: 2023, Month: time.June}
+
+// monthlyUnits returns the given units in every month from the first period up to, but not including, the last,
+// with overrides for single months.
+func monthlyUnits(from, to Period, units int, overrides map[Period]int) map[Period]Amount {
+	past := make(map[Period]Amount)
+	for p := from; p.Before(to); p = p.AddMonths(1) {
+		amount := Amount{Units: units}
+		if override, ok := overrides[p]; ok {
+			amount.Units = override
+		}
+		amount.Gross = float64(amount.Units) * 5
+		past[p] = amount
+	}
+	return past
+}
+
+func TestSeasonalExpectation(t *testing.T) {
+	lastJune := Period{Year: 2022, Month: time.June}
+	tests := []struct {
+		name     string
+		past     map[Period]Amount
+		expected float64
+		spread   float64
+		ok       bool
+	}{
+		{"no history", nil, 0, 1, false},
+		{"no earlier years", monthlyUnits(june2023.AddMonths(-6), june2023, 10, nil), 10, 1, true},
+		// Baseline (11*10 + 20) / 12, scaled by last June selling twice its preceding twelve months.
+		{"one earlier year", monthlyUnits(june2023.AddMonths(-24), june2023, 10, map[Period]int{lastJune: 20}), 130.0 / 12 * 2, 0, true},
+		// Indices 2 and 1: mean 1.5, sample deviation sqrt(0.5).
+		{"two earlier years", monthlyUnits(june2023.AddMonths(-36), june2023, 10, map[Period]int{lastJune: 20}), 130.0 / 12 * 1.5, math.Sqrt(0.5) / 1.5, true},
+		{"seasonal month without sales", monthlyUnits(june2023.AddMonths(-24), june2023, 10, map[Period]int{lastJune: 0}), 0, 1, true},
+		// The baseline ignores months without sales data rather than counting them as zero.
+		{"gaps in the baseline", map[Period]Amount{june2023.AddMonths(-1): {Units: 12}, june2023.AddMonths(-5): {Units: 4}}, 8, 1, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expected, spread, ok := seasonalExpectation(test.past, june2023)
+			if !near(expected, test.expected) || !near(spread, test.spread) || ok != test.ok {
+				t.Errorf("seasonalExpectation = %.4f, %.4f, %v, want %.4f, %.4f, %v", expected, spread, ok, test.expected, test.spread, test.ok)
+			}
+		})
+	}
+}
+
+func TestMeanAndDeviation(t *testing.T) {
+	tests := []struct {
+		values    []float64
+		mean, dev float64
+	}{
+		{[]float64{3}, 3, 0},
+		{[]float64{1, 1, 1}, 1, 0},
+		{[]float64{1, 2}, 1.5, math.Sqrt(0.5)},
+		{[]float64{2, 4, 4, 4, 5, 5, 7, 9}, 5, math.Sqrt(32.0 / 7)},
+	}
+	for _, test := range tests {
+		mean, dev := meanAndDeviation(test.values)
+		if !near(mean, test.mean) || !near(dev, test.dev) {
+			t.Errorf("meanAndDeviation(%v) = %.4f, %.4f, want %.4f, %.4f", test.values, mean, dev, test.mean, test.dev)
+		}
+	}
+}
+
+func TestForecastMonth(t *testing.T) {
+	halfway := june2023.Start().Add(15 * 24 * time.Hour)
+	flat := historyOf(t, monthlyUnits(june2023.AddMonths(-12), june2023, 10, nil))
+	tests := []struct {
+		name    string
+		current []model.SalesData
+		history History
+		now     time.Time
+		units   Band
+		gross   float64
+	}{
+		{
+			// A finished month is its own forecast.
+			name:    "finished month",
+			current: []model.SalesData{sale(10, 50)},
+			history: flat,
+			now:     june2023.AddMonths(1).Start(),
+			units:   Band{Low: 10, Expected: 10, High: 10},
+			gross:   50,
+		},
+		{
+			// Without history the run rate of 20 a month is projected alone: the remaining half month
+			// expects 10 units, with Poisson variance 10 plus 10 for the rate estimated from 10 units.
+			name:    "halfway without history",
+			current: []model.SalesData{sale(10, 50)},
+			now:     halfway,
+			units:   Band{Low: 20 - confidenceZ*math.Sqrt(20), Expected: 20, High: 20 + confidenceZ*math.Sqrt(20)},
+			gross:   100,
+		},
+		{
+			// Run rate and seasonal expectation agree on 10 a month.
+			name:    "halfway on trend",
+			current: []model.SalesData{sale(5, 25)},
+			history: flat,
+			now:     halfway,
+			units:   Band{Low: 5, Expected: 10, High: 10 + confidenceZ*math.Sqrt(30)},
+			gross:   50,
+		},
+		{
+			// A run rate of 30 a month against an expectation of 10 blends to 20, of which half remains.
+			name:    "halfway above trend",
+			current: []model.SalesData{sale(15, 75)},
+			history: flat,
+			now:     halfway,
+			units:   Band{Low: 15, Expected: 25, High: 25 + confidenceZ*math.Sqrt(110)},
+			gross:   125,
+		},
+		{
+			// Rows of the same package at different prices are forecast as one package.
+			name:    "price change",
+			current: []model.SalesData{sale(2, 10), sale(3, 20)},
+			history: flat,
+			now:     halfway,
+			units:   Band{Low: 5, Expected: 10, High: 10 + confidenceZ*math.Sqrt(30)},
+			gross:   60,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			forecast, err := ForecastMonth("202306", test.current, test.history, test.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(forecast.Packages) != 1 {
+				t.Fatalf("forecast %d packages, want 1", len(forecast.Packages))
+			}
+			units := forecast.Packages[0].Units
+			if !near(units.Low, test.units.Low) || !near(units.Expected, test.units.Expected) || !near(units.High, test.units.High) {
+				t.Errorf("units = %+v, want %+v", units, test.units)
+			}
+			if gross := forecast.Packages[0].Gross.Expected; !near(gross, test.gross) {
+				t.Errorf("gross = %.4f, want %.4f", gross, test.gross)
+			}
+			if forecast.Total.Units != units {
+				t.Errorf("total units = %+v, want %+v", forecast.Total.Units, units)
+			}
+		})
+	}
+}
+
+func sale(units int, gross float64) model.SalesData {
+	return model.SalesData{PackageId: "1", PackageName: "Package", Sales: units, Gross: fmt.Sprintf("$%.2f", gross)}
+}
+
+func historyOf(t *testing.T, past map[Period]Amount) History {
+	t.Helper()
+	sales := make(map[string][]model.SalesData, len(past))
+	for p, amount := range past {
+		sales[p.Start().Format("200601")] = []model.SalesData{sale(amount.Units, amount.Gross)}
+	}
+	history, err := NewHistory(sales)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return history
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
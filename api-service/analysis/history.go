@@ -0,0 +1,123 @@
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kwintenvdb/unity-publisher-management/api/model"
+)
+
+// Period is a single calendar month as reported by the Unity months endpoint.
+type Period struct {
+	Year  int
+	Month time.Month
+}
+
+// ParsePeriod parses a month value such as "202304" or "20230401".
+func ParsePeriod(value string) (Period, error) {
+	for _, layout := range []string{"200601", "20060102", "2006-01", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return Period{Year: t.Year(), Month: t.Month()}, nil
+		}
+	}
+	return Period{}, fmt.Errorf("unrecognized month value %q", value)
+}
+
+func PeriodOf(t time.Time) Period {
+	return Period{Year: t.Year(), Month: t.Month()}
+}
+
+func (p Period) Start() time.Time {
+	return time.Date(p.Year, p.Month, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func (p Period) Days() int {
+	return p.Start().AddDate(0, 1, -1).Day()
+}
+
+func (p Period) AddMonths(n int) Period {
+	return PeriodOf(p.Start().AddDate(0, n, 0))
+}
+
+func (p Period) Before(other Period) bool {
+	return p.Start().Before(other.Start())
+}
+
+// Amount is a single month of sales for one package.
+type Amount struct {
+	Units int
+	Gross float64
+}
+
+// MonthSales holds the sales of every package in one month.
+type MonthSales struct {
+	Period Period
+	Sales  []model.SalesData
+}
+
+// History is a publisher's monthly sales in chronological order.
+type History []MonthSales
+
+// NewHistory builds a history from sales keyed by Unity month value.
+func NewHistory(salesByMonth map[string][]model.SalesData) (History, error) {
+	history := make(History, 0, len(salesByMonth))
+	for value, sales := range salesByMonth {
+		period, err := ParsePeriod(value)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, MonthSales{Period: period, Sales: sales})
+	}
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].Period.Before(history[j].Period)
+	})
+	return history, nil
+}
+
+// Series returns the amounts of each package by period.
+// Packages are keyed by id, falling back to the name for rows that could not be resolved.
+func (h History) Series() map[string]map[Period]Amount {
+	series := make(map[string]map[Period]Amount)
+	for _, month := range h {
+		for _, s := range month.Sales {
+			key := PackageKey(s)
+			if _, ok := series[key]; !ok {
+				series[key] = make(map[Period]Amount)
+			}
+			amount := series[key][month.Period]
+			amount.Units += s.Sales
+			amount.Gross += ParseAmount(s.Gross)
+			series[key][month.Period] = amount
+		}
+	}
+	return series
+}
+
+func PackageKey(s model.SalesData) string {
+	if s.PackageId != "" {
+		return s.PackageId
+	}
+	return s.PackageName
+}
+
+// ParseAmount parses a currency string such as "$1,234.56". Unparseable amounts count as zero.
+func ParseAmount(value string) float64 {
+	cleaned := strings.Map(func(r rune) rune {
+		if (r >= '0' && r <= '9') || r == '.' || r == '-' {
+			return r
+		}
+		return -1
+	}, value)
+	amount, err := strconv.ParseFloat(cleaned, 64)
+	if err != nil {
+		return 0
+	}
+	return amount
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
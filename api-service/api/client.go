@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,19 +9,36 @@ import (
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"time"
 
 	"github.com/Kwintenvdb/unity-publisher-management/api/model"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+	"github.com/Kwintenvdb/unity-publisher-management/common/requestid"
+	"github.com/Kwintenvdb/unity-publisher-management/common/tracing"
 	"github.com/Kwintenvdb/unity-publisher-management/internal/auth"
-	"github.com/Kwintenvdb/unity-publisher-management/logger"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
 )
 
+var tracer = tracing.Tracer("github.com/Kwintenvdb/unity-publisher-management/api")
+
+// inflight shares Unity calls between the clients of concurrent requests for the same resource.
+var inflight singleflight.Group
+
+// ErrUnauthorized is returned when Unity rejects the kharma session, usually because it expired.
+var ErrUnauthorized = errors.New("unity rejected the session")
+
+// Client is created per incoming request. Its context and request id are passed on to every Unity call.
 type Client struct {
-	logger     logger.Logger
+	ctx    context.Context
+	logger logger.Logger
 }
 
-func NewClient(logger logger.Logger) *Client {
+func NewClient(ctx context.Context, l logger.Logger) *Client {
 	return &Client{
-		logger:     logger,
+		ctx:    ctx,
+		logger: logger.ForContext(l, ctx),
 	}
 }
 
@@ -90,7 +108,8 @@ func (c *Client) fetchOverview(client *http.Client) (model.Overview, error) {
 	c.logger.Debug("Fetching overview...")
 
 	// Fetch the overview data
-	res, err := client.Get("https://publisher.assetstore.unity3d.com/api/publisher/overview.json")
+	req, _ := c.newRequest("https://publisher.assetstore.unity3d.com/api/publisher/overview.json")
+	res, err := c.do("overview", client, req)
 	if err != nil {
 		return model.Overview{}, err
 	}
@@ -120,7 +139,7 @@ func (c *Client) FetchSales(publisher, month, token, session string) ([]model.Sa
 	}
 
 	var rawSales model.RawSalesData
-	err = c.getJson(fmt.Sprintf("%s/%s.json", salesUrl, month), &rawSales, token, session)
+	err = c.getJson("sales", fmt.Sprintf("%s/%s.json", salesUrl, month), sharedKey(publisher, "sales", month), &rawSales, token, session)
 	if err != nil {
 		c.logger.Errorw("Failed to fetch sales", "error", err, "month", month)
 		return nil, err
@@ -140,7 +159,7 @@ func (c *Client) FetchMonths(publisher, token, session string) ([]model.MonthDat
 	var months struct {
 		Months []model.MonthData `json:"periods"`
 	}
-	err = c.getJson(fmt.Sprintf("%s/%s.json", monthsUrl, publisher), &months, token, session)
+	err = c.getJson("months", fmt.Sprintf("%s/%s.json", monthsUrl, publisher), sharedKey(publisher, "months", ""), &months, token, session)
 	if err != nil {
 		c.logger.Errorw("Failed to fetch months", "error", err)
 		return nil, err
@@ -154,7 +173,8 @@ func (c *Client) FetchPackages(token, session string) ([]model.PackageData, erro
 	var packages struct {
 		Packages []model.PackageData `json:"packages"`
 	}
-	err := c.getJson(url, &packages, token, session)
+	// The packages belong to the session rather than to a publisher in the URL, so they are not shared.
+	err := c.getJson("packages", url, "", &packages, token, session)
 	if err != nil {
 		c.logger.Errorw("Failed to fetch packages", "error", err)
 		return nil, err
@@ -171,25 +191,94 @@ func (c *Client) getPublisherInfoUrl(publisher string, infoType string) (string,
 	return fmt.Sprintf("%s/%s/%s", baseUrl, infoType, publisher), nil
 }
 
-func (c *Client) getJson(url string, v interface{}, token, session string) error {
-	req, _ := http.NewRequest(http.MethodGet, url, nil)
+func (c *Client) newRequest(url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if id := requestid.FromContext(c.ctx); id != "" {
+		req.Header.Set(requestid.Header, id)
+	}
+	return req, nil
+}
+
+func sharedKey(publisher, resource, month string) string {
+	return publisher + "/" + resource + "/" + month
+}
+
+// getJson fetches the resource and decodes it into v. Concurrent calls with the same non-empty key share
+// one Unity call. Only successful responses are shared: when the call fails, every other caller repeats it
+// with its own session and context, so that one expired session or cancelled request does not fail the others.
+func (c *Client) getJson(endpoint, url, key string, v interface{}, token, session string) error {
+	if key == "" {
+		body, err := c.fetch(endpoint, url, token, session)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(body, v)
+	}
+
+	leader := false
+	result, err, shared := inflight.Do(key, func() (interface{}, error) {
+		leader = true
+		return c.fetch(endpoint, url, token, session)
+	})
+	if !leader {
+		coalescedRequests.WithLabelValues(endpoint).Inc()
+		if err != nil {
+			c.logger.Debugw("Repeating failed shared request", "error", err, "endpoint", endpoint)
+			result, err = c.fetch(endpoint, url, token, session)
+		}
+	} else if shared {
+		c.logger.Debugw("Shared request with concurrent callers", "endpoint", endpoint)
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(result.([]byte), v)
+}
+
+// fetch sends a single request to Unity and returns the response body.
+func (c *Client) fetch(endpoint, url, token, session string) ([]byte, error) {
+	req, err := c.newRequest(url)
+	if err != nil {
+		return nil, err
+	}
 	req.Header.Set("x-kharma-token", token)
 	req.AddCookie(&http.Cookie{Name: "kharma_session", Value: session})
 	req.AddCookie(&http.Cookie{Name: "kharma_token", Value: token})
 
-	client := http.Client{}
-	res, err := client.Do(req)
+	res, err := c.do(endpoint, &http.Client{}, req)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusUnauthorized {
+		return nil, ErrUnauthorized
 	}
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
 	}
+	return io.ReadAll(res.Body)
+}
 
-	defer res.Body.Close()
-	body, err := io.ReadAll(res.Body)
+// do sends a request to Unity inside a client span and records its duration.
+// The trace context is not propagated, as Unity is not part of our traces.
+func (c *Client) do(endpoint string, client *http.Client, req *http.Request) (*http.Response, error) {
+	ctx, span := tracer.Start(req.Context(), "unity "+endpoint,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPMethod(req.Method), semconv.HTTPURL(req.URL.String())),
+	)
+	defer span.End()
+
+	start := time.Now()
+	res, err := client.Do(req.WithContext(ctx))
+	observeUnityRequest(endpoint, start, res, err)
 	if err != nil {
-		return err
+		tracing.RecordError(span, err)
+		return nil, err
 	}
-	return json.Unmarshal(body, v)
+	span.SetAttributes(semconv.HTTPStatusCode(res.StatusCode))
+	return res, nil
 }
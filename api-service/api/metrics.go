@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var unityRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "upm",
+	Subsystem: "unity",
+	Name:      "request_duration_seconds",
+	Help:      "Duration of requests to the Unity publisher API by endpoint and status.",
+	Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+}, []string{"endpoint", "status"})
+
+var coalescedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "upm",
+	Subsystem: "unity",
+	Name:      "coalesced_requests_total",
+	Help:      "Requests to the Unity publisher API that waited for a concurrent identical request instead, by endpoint.",
+}, []string{"endpoint"})
+
+// observeUnityRequest records a finished Unity call. Requests that failed without a response are recorded with status "error".
+func observeUnityRequest(endpoint string, start time.Time, res *http.Response, err error) {
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(res.StatusCode)
+	}
+	unityRequestDuration.WithLabelValues(endpoint, status).Observe(time.Since(start).Seconds())
+}
@@ -9,6 +9,7 @@ type RawSalesData struct {
 }
 
 type SalesData struct {
+	PackageId   string `json:"package_id"`
 	PackageName string `json:"package_name"`
 	Price       string `json:"price"`
 	Sales       int    `json:"sales"`
@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Kwintenvdb/unity-publisher-management/api/model"
+	"github.com/Kwintenvdb/unity-publisher-management/common/jwtauth"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+	"github.com/Kwintenvdb/unity-publisher-management/common/requestid"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// Client reads sales from the caching service.
+type Client struct {
+	logger logger.Logger
+	host   string
+	client *http.Client
+}
+
+func NewClient(logger logger.Logger, host string) *Client {
+	return &Client{
+		logger: logger,
+		host:   host,
+		client: &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)},
+	}
+}
+
+// FetchSales returns the cached sales of a month. The boolean reports whether the month was cached.
+// The caching service is called with the user token of the request in ctx.
+func (c *Client) FetchSales(ctx context.Context, publisher, month string) ([]model.SalesData, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s/sales/%s/%s", c.host, publisher, month), nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if id := requestid.FromContext(ctx); id != "" {
+		req.Header.Set(requestid.Header, id)
+	}
+	jwtauth.SetBearer(req, jwtauth.TokenFromContext(ctx))
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	var sales []model.SalesData
+	if err := json.NewDecoder(res.Body).Decode(&sales); err != nil {
+		return nil, false, err
+	}
+	return sales, true, nil
+}
@@ -0,0 +1,146 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Kwintenvdb/unity-publisher-management/analysis"
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+	"github.com/Kwintenvdb/unity-publisher-management/common/events"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+	"github.com/Kwintenvdb/unity-publisher-management/common/messaging"
+	"github.com/Kwintenvdb/unity-publisher-management/common/requestid"
+	"github.com/Kwintenvdb/unity-publisher-management/common/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// AnomalyNotifier publishes detected anomalies as events.AnomalyDetected.
+// Each anomaly is only published once, however often it is detected.
+type AnomalyNotifier struct {
+	logger    logger.Logger
+	publisher messaging.Publisher
+	sent      SentAnomalies
+	wg        sync.WaitGroup
+}
+
+func NewAnomalyNotifier(logger logger.Logger, publisher messaging.Publisher, sent SentAnomalies) *AnomalyNotifier {
+	return &AnomalyNotifier{
+		logger:    logger,
+		publisher: publisher,
+		sent:      sent,
+	}
+}
+
+// NotifyAsync publishes the anomalies in the background. Failures are logged.
+// Only the request id and span are taken from the context, as the request may finish before publishing does.
+func (n *AnomalyNotifier) NotifyAsync(ctx context.Context, publisher string, anomalies []analysis.Anomaly) {
+	detached := requestid.NewContext(context.Background(), requestid.FromContext(ctx))
+	ctx = trace.ContextWithSpanContext(detached, trace.SpanContextFromContext(ctx))
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		if err := n.Notify(ctx, publisher, anomalies); err != nil {
+			logger.ForContext(n.logger, ctx).Errorw("Failed to publish anomalies", "error", err, "publisher", publisher)
+		}
+	}()
+}
+
+// Close waits until all background notifications finished or the context is done, and closes the publisher.
+func (n *AnomalyNotifier) Close(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if closeErr := n.publisher.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (n *AnomalyNotifier) Notify(ctx context.Context, publisher string, anomalies []analysis.Anomaly) error {
+	headers := messaging.Headers{}
+	if id := requestid.FromContext(ctx); id != "" {
+		headers[requestid.Header] = id
+	}
+
+	keys := make([]string, len(anomalies))
+	for i, a := range anomalies {
+		keys[i] = fmt.Sprintf("%s/%s/%s/%s/%s", publisher, a.PackageId, a.PackageName, a.Month, a.Kind)
+	}
+	sent, err := n.sent.Sent(ctx, keys)
+	if err != nil {
+		return err
+	}
+
+	var messages []messaging.Message
+	var unsent []string
+	var expire time.Time
+	for i, a := range anomalies {
+		if sent[i] {
+			continue
+		}
+		value, err := events.Marshal(config.ApiService, events.AnomalyDetected{
+			Publisher:   publisher,
+			PackageId:   a.PackageId,
+			PackageName: a.PackageName,
+			Month:       a.Month,
+			Kind:        string(a.Kind),
+			UnitsToDate: a.UnitsToDate,
+			Projected:   a.Projected,
+			Baseline:    a.Baseline,
+			ZScore:      a.ZScore,
+		})
+		if err != nil {
+			return err
+		}
+		messages = append(messages, messaging.Message{
+			Topic: events.AnomaliesTopic,
+			Key:   []byte(fmt.Sprintf("sales.anomaly.%s", publisher)),
+			Value: value,
+		})
+		unsent = append(unsent, keys[i])
+		if e := sentExpiry(a.Month); e.After(expire) {
+			expire = e
+		}
+	}
+
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ctx, span := tracing.StartPublish(ctx, events.AnomaliesTopic, headers)
+	defer span.End()
+	for i := range messages {
+		messages[i].Headers = headers
+	}
+
+	if err := n.publisher.Publish(ctx, messages...); err != nil {
+		tracing.RecordError(span, err)
+		return err
+	}
+	logger.ForContext(n.logger, ctx).Infow("Published sales anomalies", "publisher", publisher, "count", len(messages))
+
+	return n.sent.Record(ctx, unsent, expire)
+}
+
+// sentExpiry returns until when a published anomaly of the month is remembered: anomalies are only detected
+// in the latest month, which stays the latest until Unity lists the next one, so until the end of that one.
+// Anomalies of a month that cannot be parsed are remembered for a day.
+func sentExpiry(month string) time.Time {
+	period, err := analysis.ParsePeriod(month)
+	if err != nil {
+		return time.Now().Add(24 * time.Hour)
+	}
+	return period.AddMonths(2).Start()
+}
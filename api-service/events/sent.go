@@ -0,0 +1,103 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// SentAnomalies remembers which anomalies were published, until their month can no longer be detected.
+type SentAnomalies interface {
+	// Sent reports for each key whether it was recorded and has not expired.
+	Sent(ctx context.Context, keys []string) ([]bool, error)
+	// Record remembers the keys until expire.
+	Record(ctx context.Context, keys []string, expire time.Time) error
+}
+
+// MemorySentAnomalies is neither shared between replicas nor kept across restarts, so each replica publishes
+// an anomaly once after it starts.
+type MemorySentAnomalies struct {
+	mutex sync.Mutex
+	sent  map[string]time.Time
+}
+
+func NewMemorySentAnomalies() *MemorySentAnomalies {
+	return &MemorySentAnomalies{sent: make(map[string]time.Time)}
+}
+
+func (m *MemorySentAnomalies) Sent(ctx context.Context, keys []string) ([]bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	sent := make([]bool, len(keys))
+	for i, key := range keys {
+		expire, ok := m.sent[key]
+		sent[i] = ok && now.Before(expire)
+	}
+	return sent, nil
+}
+
+// Record also forgets the expired keys.
+func (m *MemorySentAnomalies) Record(ctx context.Context, keys []string, expire time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	for key, expire := range m.sent {
+		if !now.Before(expire) {
+			delete(m.sent, key)
+		}
+	}
+	for _, key := range keys {
+		m.sent[key] = expire
+	}
+	return nil
+}
+
+const redisSentPrefix = "upm:anomaly-sent:"
+
+// RedisSentAnomalies shares the published anomalies between api-service replicas and restarts.
+// Replicas detecting the same anomaly at the same moment may both publish it.
+type RedisSentAnomalies struct {
+	client *redis.Client
+}
+
+func NewRedisSentAnomalies(client *redis.Client) *RedisSentAnomalies {
+	return &RedisSentAnomalies{client: client}
+}
+
+func (r *RedisSentAnomalies) Sent(ctx context.Context, keys []string) ([]bool, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = redisSentPrefix + key
+	}
+	values, err := r.client.MGet(ctx, prefixed...).Result()
+	if err != nil {
+		return nil, err
+	}
+	sent := make([]bool, len(keys))
+	for i, value := range values {
+		sent[i] = value != nil
+	}
+	return sent, nil
+}
+
+func (r *RedisSentAnomalies) Record(ctx context.Context, keys []string, expire time.Time) error {
+	ttl := time.Until(expire)
+	if ttl <= 0 {
+		return nil
+	}
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Set(ctx, redisSentPrefix+key, 1, ttl)
+		}
+		return nil
+	})
+	return err
+}
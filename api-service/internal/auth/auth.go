@@ -7,7 +7,7 @@ import (
 	"net/url"
 	"strings"
 
-	"github.com/Kwintenvdb/unity-publisher-management/logger"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
 	"github.com/PuerkitoBio/goquery"
 )
 
@@ -0,0 +1,119 @@
+package packages
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Kwintenvdb/unity-publisher-management/api/model"
+)
+
+// AliasStore is the table of package names by publisher.
+// Unity reports sales by package name only, so the table remembers old names after a rename: a name resolves
+// to the package that was last recorded under it, so the sales of every month resolve to the same package
+// whichever name it was sold under.
+type AliasStore interface {
+	// Aliases returns the id of every name the packages of the publisher have been sold under.
+	Aliases(ctx context.Context, publisher string) (map[string]string, error)
+	// Record registers the name of each package as one of its names, keeping any previous name.
+	// A name recorded for another package before now names this one.
+	Record(ctx context.Context, publisher string, packages []model.PackageData) error
+}
+
+// publisherAliases maps every name a package has ever been sold under to its id.
+type publisherAliases struct {
+	Names map[string]string `json:"names"`
+}
+
+// FileAliasStore keeps the table in a file. It is not shared between replicas, so it only suits a single one.
+type FileAliasStore struct {
+	path    string
+	mutex   sync.RWMutex
+	aliases map[string]*publisherAliases
+}
+
+func NewFileAliasStore(path string) (*FileAliasStore, error) {
+	store := &FileAliasStore{
+		path:    path,
+		aliases: make(map[string]*publisherAliases),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &store.aliases); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *FileAliasStore) Aliases(ctx context.Context, publisher string) (map[string]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	names := make(map[string]string)
+	if aliases, ok := s.aliases[publisher]; ok {
+		for name, id := range aliases.Names {
+			names[name] = id
+		}
+	}
+	return names, nil
+}
+
+// Record saves the table only if it changed.
+func (s *FileAliasStore) Record(ctx context.Context, publisher string, packages []model.PackageData) error {
+	s.mutex.Lock()
+	aliases, ok := s.aliases[publisher]
+	if !ok {
+		aliases = &publisherAliases{Names: make(map[string]string)}
+		s.aliases[publisher] = aliases
+	}
+
+	changed := false
+	for _, p := range packages {
+		if aliases.Names[p.Name] == p.Id {
+			continue
+		}
+		aliases.Names[p.Name] = p.Id
+		changed = true
+	}
+	s.mutex.Unlock()
+
+	if !changed {
+		return nil
+	}
+	return s.save()
+}
+
+// save writes the table to disk, replacing the previous file atomically.
+func (s *FileAliasStore) save() error {
+	s.mutex.RLock()
+	data, err := json.MarshalIndent(s.aliases, "", "  ")
+	s.mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
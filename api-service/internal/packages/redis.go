@@ -0,0 +1,36 @@
+package packages
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/Kwintenvdb/unity-publisher-management/api/model"
+)
+
+const redisAliasPrefix = "upm:package-aliases:"
+
+// RedisAliasStore shares the table between api-service replicas, so every replica resolves a name to the same id.
+// The names of each publisher are a hash of package ids by name.
+type RedisAliasStore struct {
+	client *redis.Client
+}
+
+func NewRedisAliasStore(client *redis.Client) *RedisAliasStore {
+	return &RedisAliasStore{client: client}
+}
+
+func (s *RedisAliasStore) Aliases(ctx context.Context, publisher string) (map[string]string, error) {
+	return s.client.HGetAll(ctx, redisAliasPrefix+publisher).Result()
+}
+
+func (s *RedisAliasStore) Record(ctx context.Context, publisher string, packages []model.PackageData) error {
+	if len(packages) == 0 {
+		return nil
+	}
+	names := make(map[string]interface{}, len(packages))
+	for _, p := range packages {
+		names[p.Name] = p.Id
+	}
+	return s.client.HSet(ctx, redisAliasPrefix+publisher, names).Err()
+}
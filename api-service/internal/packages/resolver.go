@@ -0,0 +1,100 @@
+package packages
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Kwintenvdb/unity-publisher-management/api/model"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+)
+
+// refreshInterval is how long after refreshing the aliases of a publisher unknown names are left unresolved
+// rather than fetching the packages again, as the sales of a deleted package may name none of them.
+const refreshInterval = 10 * time.Minute
+
+// Resolver joins sales rows to stable package ids.
+type Resolver struct {
+	aliases AliasStore
+	logger  logger.Logger
+
+	mutex     sync.Mutex
+	refreshed map[string]time.Time
+}
+
+func NewResolver(aliases AliasStore, logger logger.Logger) *Resolver {
+	return &Resolver{
+		aliases:   aliases,
+		logger:    logger,
+		refreshed: make(map[string]time.Time),
+	}
+}
+
+// Learn records the current name of each package so that sales under older names can still be resolved after a rename.
+func (r *Resolver) Learn(ctx context.Context, publisher string, packages []model.PackageData) error {
+	return r.aliases.Record(ctx, publisher, packages)
+}
+
+// Resolve sets the PackageId of each sales row. If a row names a package the table does not know, the current
+// packages are fetched and learned first, at most once per refresh interval for each publisher.
+// Rows for packages that were never seen under that name keep an empty id.
+func (r *Resolver) Resolve(ctx context.Context, publisher string, sales []model.SalesData, fetch func() ([]model.PackageData, error)) []model.SalesData {
+	log := logger.ForContext(r.logger, ctx)
+	aliases, err := r.aliases.Aliases(ctx, publisher)
+	if err != nil {
+		log.Warnw("Failed to read package aliases", "error", err, "publisher", publisher)
+		return sales
+	}
+
+	if unknown(aliases, sales) && r.startRefresh(publisher) {
+		packages, err := fetch()
+		if err == nil {
+			err = r.Learn(ctx, publisher, packages)
+		}
+		if err != nil {
+			log.Warnw("Failed to update package aliases", "error", err, "publisher", publisher)
+		}
+		// Learning adds names to the table but never removes any, so the names read before are still valid.
+		for _, p := range packages {
+			aliases[p.Name] = p.Id
+		}
+	}
+
+	for i := range sales {
+		id, ok := aliases[sales[i].PackageName]
+		if !ok {
+			log.Warnw("Could not resolve package id", "publisher", publisher, "package", sales[i].PackageName)
+			continue
+		}
+		sales[i].PackageId = id
+	}
+	return sales
+}
+
+// startRefresh reports whether the aliases of the publisher are due for a refresh, and if so, records
+// the refresh so concurrent requests do not start one as well.
+func (r *Resolver) startRefresh(publisher string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	for p, refreshed := range r.refreshed {
+		if now.Sub(refreshed) >= refreshInterval {
+			delete(r.refreshed, p)
+		}
+	}
+	if _, ok := r.refreshed[publisher]; ok {
+		return false
+	}
+	r.refreshed[publisher] = now
+	return true
+}
+
+func unknown(aliases map[string]string, sales []model.SalesData) bool {
+	for _, s := range sales {
+		if _, ok := aliases[s.PackageName]; !ok {
+			return true
+		}
+	}
+	return false
+}
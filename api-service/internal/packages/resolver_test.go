@@ -0,0 +1,124 @@
+package packages
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/Kwintenvdb/unity-publisher-management/api/model"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+)
+
+func TestResolveRenames(t *testing.T) {
+	stores := []struct {
+		name  string
+		store func(t *testing.T) AliasStore
+	}{
+		{"file", func(t *testing.T) AliasStore {
+			store, err := NewFileAliasStore(filepath.Join(t.TempDir(), "aliases.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		}},
+		{"redis", func(t *testing.T) AliasStore { return NewRedisAliasStore(testRedis(t)) }},
+	}
+	tests := []struct {
+		name string
+		// At each step, the packages Unity lists, and the names of the sales requested, months apart.
+		listed [][]model.PackageData
+		sales  [][]string
+		// The ids the names of each step resolve to.
+		ids [][]string
+	}{
+		{
+			// Sales of the months before the rename are still resolved once the new name was learned.
+			name:   "renamed between months",
+			listed: [][]model.PackageData{{{Id: "1", Name: "Old"}}, {{Id: "1", Name: "New"}}},
+			sales:  [][]string{{"Old"}, {"New", "Old"}},
+			ids:    [][]string{{"1"}, {"1", "1"}},
+		},
+		{
+			// A name that was never listed cannot be resolved.
+			name:   "renamed before any listing",
+			listed: [][]model.PackageData{{{Id: "1", Name: "New"}}},
+			sales:  [][]string{{"New", "Old"}},
+			ids:    [][]string{{"1", ""}},
+		},
+		{
+			// A name taken over by another package names that package from then on.
+			name:   "name taken over",
+			listed: [][]model.PackageData{{{Id: "1", Name: "Pro"}}, {{Id: "1", Name: "Pro Classic"}, {Id: "2", Name: "Pro"}}},
+			sales:  [][]string{{"Pro"}, {"Pro Classic", "Pro"}},
+			ids:    [][]string{{"1"}, {"1", "2"}},
+		},
+	}
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			for _, test := range tests {
+				t.Run(test.name, func(t *testing.T) {
+					resolver := NewResolver(s.store(t), logger.NewLogger())
+					ctx := context.Background()
+					publisher := "publisher-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+					for step, names := range test.sales {
+						// Steps are months apart, so the packages are fetched again for unknown names.
+						resolver.refreshed = make(map[string]time.Time)
+						fetch := func() ([]model.PackageData, error) {
+							return test.listed[step], nil
+						}
+						var sales []model.SalesData
+						for _, name := range names {
+							sales = append(sales, model.SalesData{PackageName: name})
+						}
+						for i, sale := range resolver.Resolve(ctx, publisher, sales, fetch) {
+							if sale.PackageId != test.ids[step][i] {
+								t.Errorf("step %d: %q resolved to %q, want %q", step, sale.PackageName, sale.PackageId, test.ids[step][i])
+							}
+						}
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestResolveRefreshesOncePerInterval(t *testing.T) {
+	store, err := NewFileAliasStore(filepath.Join(t.TempDir(), "aliases.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := NewResolver(store, logger.NewLogger())
+	fetches := 0
+	fetch := func() ([]model.PackageData, error) {
+		fetches++
+		return []model.PackageData{{Id: "1", Name: "Package"}}, nil
+	}
+
+	ctx := context.Background()
+	sales := resolver.Resolve(ctx, "publisher", []model.SalesData{{PackageName: "Package"}, {PackageName: "Deleted"}}, fetch)
+	if sales[0].PackageId != "1" || sales[1].PackageId != "" {
+		t.Errorf("resolved %q and %q, want 1 and none", sales[0].PackageId, sales[1].PackageId)
+	}
+	// The deleted package is never listed, so it does not fetch the packages again within the interval.
+	resolver.Resolve(ctx, "publisher", []model.SalesData{{PackageName: "Deleted"}}, fetch)
+	if fetches != 1 {
+		t.Errorf("fetched %d times, want 1", fetches)
+	}
+}
+
+// testRedis returns a client of the Redis at UPM_TEST_REDIS_ADDR, and skips the test if none is given.
+func testRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	addr := os.Getenv("UPM_TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("UPM_TEST_REDIS_ADDR is not set")
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
 	"github.com/Kwintenvdb/unity-publisher-management/server"
 )
 
 func main() {
-	server.Start()
+	cfg, err := config.Load(config.ApiService)
+	if err != nil {
+		panic(err)
+	}
+	server.Start(cfg)
 }
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+	"github.com/Kwintenvdb/unity-publisher-management/common/health"
+	"github.com/Kwintenvdb/unity-publisher-management/internal/auth"
+)
+
+// readinessChecks returns the checks for the dependencies of the api-service.
+// Unity reachability is opt-in as a Unity outage should not take every replica out of rotation.
+func readinessChecks(cfg *config.Config, redisClient func() *redis.Client) []health.Check {
+	var checks []health.Check
+	if usesRedis(cfg) {
+		checks = append(checks, health.Check{Name: "redis", Check: func(ctx context.Context) error {
+			return redisClient().Ping(ctx).Err()
+		}})
+	}
+	if cfg.ApiService.ReadyCheckUnity {
+		checks = append(checks, health.Check{Name: "unity", Check: checkUnityLogin})
+	}
+	return checks
+}
+
+func checkUnityLogin(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, auth.LOGIN_URL, nil)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/Kwintenvdb/unity-publisher-management/analysis"
+	"github.com/Kwintenvdb/unity-publisher-management/api"
+	"github.com/Kwintenvdb/unity-publisher-management/api/model"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+)
+
+// The history falls back to Unity for at most maxUnityMonths months the cache does not hold, the newest first,
+// fetching unityConcurrency of them at a time. Older months are left out until the scheduler caches them.
+const (
+	maxUnityMonths   = 24
+	unityConcurrency = 4
+)
+
+// partialHistoryHeader marks a response computed without some previous months, with the number left out.
+const partialHistoryHeader = "X-Partial-History"
+
+type salesHistory struct {
+	month string
+	sales []model.SalesData
+	past  analysis.History
+	// Previous months left out, as Unity was not asked for them or failed to return them.
+	missing []string
+}
+
+// markPartial sets the partial history header if months were left out of the history.
+func (h *salesHistory) markPartial(c *gin.Context) {
+	if len(h.missing) > 0 {
+		c.Header(partialHistoryHeader, strconv.Itoa(len(h.missing)))
+	}
+}
+
+// loadSalesHistory fetches the in-progress month from Unity and all previous months from the cache,
+// falling back to Unity for a bounded number of months that have not been cached yet.
+func (s *server) loadSalesHistory(ctx context.Context, publisher, token, session string) (*salesHistory, error) {
+	log := logger.ForContext(s.logger, ctx)
+	apiClient := api.NewClient(ctx, s.logger)
+
+	months, err := apiClient.FetchMonths(publisher, token, session)
+	if err != nil {
+		return nil, err
+	}
+	current, err := latestMonth(months)
+	if err != nil {
+		return nil, err
+	}
+
+	sales, err := apiClient.FetchSales(publisher, current.Value, token, session)
+	if err != nil {
+		return nil, err
+	}
+	fetchPackages := func() ([]model.PackageData, error) {
+		return apiClient.FetchPackages(token, session)
+	}
+
+	past := make(map[string][]model.SalesData)
+	var uncached []string
+	for _, month := range months {
+		if month.Value == current.Value {
+			continue
+		}
+
+		monthSales, found, err := s.cache.FetchSales(ctx, publisher, month.Value)
+		if err != nil {
+			log.Warnw("Failed to read sales from cache", "error", err, "month", month.Value)
+		}
+		if !found {
+			uncached = append(uncached, month.Value)
+			continue
+		}
+		past[month.Value] = monthSales
+	}
+
+	fetched, missing, err := s.fetchUncachedSales(ctx, publisher, uncached, token, session)
+	if err != nil {
+		return nil, err
+	}
+	for month, monthSales := range fetched {
+		past[month] = monthSales
+	}
+	if len(missing) > 0 {
+		log.Infow("Leaving months out of the sales history", "publisher", publisher, "missing", len(missing))
+	}
+	for month, monthSales := range past {
+		past[month] = s.packages.Resolve(ctx, publisher, monthSales, fetchPackages)
+	}
+
+	history, err := analysis.NewHistory(past)
+	if err != nil {
+		return nil, err
+	}
+	return &salesHistory{
+		month:   current.Value,
+		sales:   s.packages.Resolve(ctx, publisher, sales, fetchPackages),
+		past:    history,
+		missing: missing,
+	}, nil
+}
+
+// fetchUncachedSales fetches the sales of the newest maxUnityMonths of the given months from Unity, and returns
+// the months it did not fetch or Unity failed to return. It only fails if Unity rejects the session.
+func (s *server) fetchUncachedSales(ctx context.Context, publisher string, months []string, token, session string) (map[string][]model.SalesData, []string, error) {
+	log := logger.ForContext(s.logger, ctx)
+
+	sort.Slice(months, func(i, j int) bool {
+		a, _ := analysis.ParsePeriod(months[i])
+		b, _ := analysis.ParsePeriod(months[j])
+		return b.Before(a)
+	})
+	var missing []string
+	if len(months) > maxUnityMonths {
+		missing = append(missing, months[maxUnityMonths:]...)
+		months = months[:maxUnityMonths]
+	}
+
+	var mutex sync.Mutex
+	fetched := make(map[string][]model.SalesData, len(months))
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(unityConcurrency)
+	apiClient := api.NewClient(groupCtx, s.logger)
+	for _, month := range months {
+		month := month
+		group.Go(func() error {
+			sales, err := apiClient.FetchSales(publisher, month, token, session)
+			if errors.Is(err, api.ErrUnauthorized) {
+				return err
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				log.Warnw("Failed to fetch sales of uncached month", "error", err, "month", month)
+				missing = append(missing, month)
+				return nil
+			}
+			fetched[month] = sales
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, nil, err
+	}
+	return fetched, missing, nil
+}
+
+func latestMonth(months []model.MonthData) (model.MonthData, error) {
+	var latest model.MonthData
+	var latestPeriod analysis.Period
+	for _, month := range months {
+		period, err := analysis.ParsePeriod(month.Value)
+		if err != nil {
+			return model.MonthData{}, err
+		}
+		if latest.Value == "" || latestPeriod.Before(period) {
+			latest = month
+			latestPeriod = period
+		}
+	}
+	if latest.Value == "" {
+		return model.MonthData{}, errors.New("publisher has no sales months")
+	}
+	return latest, nil
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+	"github.com/Kwintenvdb/unity-publisher-management/events"
+	"github.com/Kwintenvdb/unity-publisher-management/internal/packages"
+)
+
+// sharedRedis returns a function that connects to Redis on its first call, so the stores that keep their
+// state in Redis share one connection pool, and none is opened if no store keeps its state in Redis.
+func sharedRedis(cfg config.RedisConfig) func() *redis.Client {
+	var once sync.Once
+	var client *redis.Client
+	return func() *redis.Client {
+		once.Do(func() {
+			client = redis.NewClient(&redis.Options{
+				Addr:     cfg.Addr,
+				Password: cfg.Password,
+				DB:       cfg.DB,
+			})
+		})
+		return client
+	}
+}
+
+// usesRedis reports whether any store of the api-service keeps its state in Redis.
+func usesRedis(cfg *config.Config) bool {
+	return cfg.ApiService.PackageAliasStore == "redis" || cfg.ApiService.SentAnomalyStore == "redis"
+}
+
+// newAliasStore returns the package alias table the config chooses, Redis to share it between replicas.
+func newAliasStore(cfg *config.Config, redisClient func() *redis.Client) (packages.AliasStore, error) {
+	if cfg.ApiService.PackageAliasStore == "redis" {
+		return packages.NewRedisAliasStore(redisClient()), nil
+	}
+	return packages.NewFileAliasStore(cfg.ApiService.PackageAliases)
+}
+
+func newSentAnomalies(cfg *config.Config, redisClient func() *redis.Client) events.SentAnomalies {
+	if cfg.ApiService.SentAnomalyStore == "redis" {
+		return events.NewRedisSentAnomalies(redisClient())
+	}
+	return events.NewMemorySentAnomalies()
+}
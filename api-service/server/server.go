@@ -1,19 +1,41 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"net/http"
-	// "time"
+	"time"
 
+	"github.com/Kwintenvdb/unity-publisher-management/analysis"
 	"github.com/Kwintenvdb/unity-publisher-management/api"
-	"github.com/Kwintenvdb/unity-publisher-management/logger"
+	"github.com/Kwintenvdb/unity-publisher-management/api/model"
+	"github.com/Kwintenvdb/unity-publisher-management/cache"
+	"github.com/Kwintenvdb/unity-publisher-management/common/browser"
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+	"github.com/Kwintenvdb/unity-publisher-management/common/health"
+	"github.com/Kwintenvdb/unity-publisher-management/common/jwtauth"
+	"github.com/Kwintenvdb/unity-publisher-management/common/lifecycle"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+	"github.com/Kwintenvdb/unity-publisher-management/common/messaging"
+	"github.com/Kwintenvdb/unity-publisher-management/common/requestid"
+	"github.com/Kwintenvdb/unity-publisher-management/common/session"
+	"github.com/Kwintenvdb/unity-publisher-management/common/tracing"
+	"github.com/Kwintenvdb/unity-publisher-management/events"
+	"github.com/Kwintenvdb/unity-publisher-management/internal/packages"
 
 	// jwt "github.com/appleboy/gin-jwt/v2"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 type server struct {
-	logger logger.Logger
+	logger   logger.Logger
+	browser  config.BrowserConfig
+	packages *packages.Resolver
+	cache    *cache.Client
+	notifier *events.AnomalyNotifier
 }
 
 type user struct {
@@ -21,13 +43,35 @@ type user struct {
 	PublisherId string
 }
 
-func Start() {
-	logger := logger.NewLogger()
+func Start(cfg *config.Config) {
+	log := logger.NewLogger()
+
+	shutdownTracing, err := tracing.Setup(config.ApiService, cfg.Tracing)
+	if err != nil {
+		log.Fatalw("Failed to set up tracing", "error", err)
+	}
+
+	redisClient := sharedRedis(cfg.Redis)
+	aliases, err := newAliasStore(cfg, redisClient)
+	if err != nil {
+		log.Fatalw("Failed to load package aliases", "error", err)
+	}
+
 	server := server{
-		logger: logger,
+		logger:   log,
+		browser:  cfg.Browser,
+		packages: packages.NewResolver(aliases, log),
+		cache:    cache.NewClient(log, cfg.CachingService.Host),
+		notifier: events.NewAnomalyNotifier(log, messaging.New(cfg.Kafka).Publisher(), newSentAnomalies(cfg, redisClient)),
 	}
 
-	r := gin.Default()
+	r := gin.New()
+	r.Use(gin.Recovery(), otelgin.Middleware(string(config.ApiService)), requestid.Middleware(), logger.AccessLog(log))
+	r.Use(browser.CheckOrigin(cfg.Browser))
+
+	r.GET("/healthz", gin.WrapF(health.Healthz))
+	r.GET("/readyz", gin.WrapF(health.Readyz(readinessChecks(cfg, redisClient)...)))
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
 	r.POST("/authenticate", func(c *gin.Context) {
 		email, publisher, err := server.authenticate(c)
@@ -42,12 +86,30 @@ func Start() {
 		c.JSON(http.StatusOK, u)
 	})
 
-	api := r.Group("/api")
-	api.GET("/sales/:publisher/:month", server.fetchSales)
-	api.GET("/months/:publisher", server.fetchMonths)
+	keys := jwtauth.NewRemoteKeys(cfg.Auth.JWKSURL, &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)})
+	users := jwtauth.NewVerifier(keys, cfg.Auth.Issuer, cfg.Auth.Audience)
+	// The scheduler calls the api on behalf of publishers with tokens of its own.
+	scheduler, err := jwtauth.ServiceVerifier(config.Scheduler, cfg.Scheduler.PublicKey, config.ApiService)
+	if err != nil {
+		log.Fatalw("Failed to load scheduler key", "error", err)
+	}
+	ownPublisher := jwtauth.RequirePublisher("publisher")
+
+	api := r.Group("/api", jwtauth.Middleware(users, scheduler))
+	api.GET("/sales/:publisher/:month", ownPublisher, server.fetchSales)
+	api.GET("/months/:publisher", ownPublisher, server.fetchMonths)
 	api.GET("/packages", server.fetchPackages)
+	api.GET("/forecast/:publisher", ownPublisher, server.fetchForecast)
+	api.GET("/anomalies/:publisher", ownPublisher, server.fetchAnomalies)
 
-	r.Run(":8081")
+	srv := &http.Server{
+		Addr:    cfg.ApiService.Addr,
+		Handler: r,
+	}
+	// Anomaly notifications are waited for first so their spans are flushed as well.
+	lifecycle.Serve(srv, cfg.ShutdownTimeout, log, func(ctx context.Context) error {
+		return errors.Join(server.notifier.Close(ctx), shutdownTracing(ctx))
+	})
 }
 
 func (s *server) authenticate(c *gin.Context) (string, string, error) {
@@ -58,14 +120,14 @@ func (s *server) authenticate(c *gin.Context) (string, string, error) {
 		return "", "", errors.New("missing email or password")
 	}
 
-	apiClient := api.NewClient(s.logger)
+	apiClient := api.NewClient(c.Request.Context(), s.logger)
 	authResponse, err := apiClient.Authenticate(email, password)
 	if err != nil {
 		return "", "", errors.New("failed to authenticate")
 	}
 
-	c.SetCookie("kharma_token", authResponse.KharmaToken, 0, "", "", false, true)
-	c.SetCookie("kharma_session", authResponse.KharmaSession, 0, "", "", false, true)
+	browser.SetCookie(c.Writer, s.browser, "kharma_token", authResponse.KharmaToken, 0)
+	browser.SetCookie(c.Writer, s.browser, "kharma_session", authResponse.KharmaSession, 0)
 
 	return email, authResponse.PublisherId, nil
 }
@@ -73,35 +135,38 @@ func (s *server) authenticate(c *gin.Context) (string, string, error) {
 func (s *server) fetchSales(c *gin.Context) {
 	token, session, err := getSessionData(c)
 	if err != nil {
-		c.String(http.StatusUnauthorized, "Failed to authenticate")
+		sessionExpired(c)
 		return
 	}
 
 	publisher := c.Param("publisher")
 	month := c.Param("month")
 
-	apiClient := api.NewClient(s.logger)
+	apiClient := api.NewClient(c.Request.Context(), s.logger)
 	sales, err := apiClient.FetchSales(publisher, month, token, session)
 	if err != nil {
-		c.String(http.StatusInternalServerError, "Failed to fetch sales")
+		failUnityRequest(c, err, "Failed to fetch sales")
 		return
 	}
-	c.JSON(http.StatusOK, sales)
+
+	c.JSON(http.StatusOK, s.packages.Resolve(c.Request.Context(), publisher, sales, func() ([]model.PackageData, error) {
+		return apiClient.FetchPackages(token, session)
+	}))
 }
 
 func (s *server) fetchMonths(c *gin.Context) {
 	token, session, err := getSessionData(c)
 	if err != nil {
-		c.String(http.StatusUnauthorized, "Failed to authenticate")
+		sessionExpired(c)
 		return
 	}
 
 	publisher := c.Param("publisher")
 
-	apiClient := api.NewClient(s.logger)
+	apiClient := api.NewClient(c.Request.Context(), s.logger)
 	months, err := apiClient.FetchMonths(publisher, token, session)
 	if err != nil {
-		c.String(http.StatusInternalServerError, "Failed to fetch months")
+		failUnityRequest(c, err, "Failed to fetch months")
 		return
 	}
 	c.JSON(http.StatusOK, months)
@@ -110,19 +175,101 @@ func (s *server) fetchMonths(c *gin.Context) {
 func (s *server) fetchPackages(c *gin.Context) {
 	token, session, err := getSessionData(c)
 	if err != nil {
-		c.String(http.StatusUnauthorized, "Failed to authenticate")
+		sessionExpired(c)
 		return
 	}
 
-	apiClient := api.NewClient(s.logger)
+	apiClient := api.NewClient(c.Request.Context(), s.logger)
 	packages, err := apiClient.FetchPackages(token, session)
 	if err != nil {
-		c.String(http.StatusInternalServerError, "Failed to fetch packages")
+		failUnityRequest(c, err, "Failed to fetch packages")
 		return
 	}
+	// Learn the current package names so sales under them, and under the names before a rename, resolve to the
+	// same id. If this fails, names seen previously still resolve.
+	if claims, ok := jwtauth.ClaimsFromContext(c.Request.Context()); ok && claims.Publisher != "" {
+		if err := s.packages.Learn(c.Request.Context(), claims.Publisher, packages); err != nil {
+			s.requestLogger(c).Warnw("Failed to update package aliases", "error", err, "publisher", claims.Publisher)
+		}
+	}
 	c.JSON(http.StatusOK, packages)
 }
 
+func (s *server) fetchForecast(c *gin.Context) {
+	token, session, err := getSessionData(c)
+	if err != nil {
+		sessionExpired(c)
+		return
+	}
+
+	publisher := c.Param("publisher")
+
+	history, err := s.loadSalesHistory(c.Request.Context(), publisher, token, session)
+	if err != nil {
+		s.requestLogger(c).Errorw("Failed to load sales history", "error", err, "publisher", publisher)
+		failUnityRequest(c, err, "Failed to fetch sales history")
+		return
+	}
+
+	forecast, err := analysis.ForecastMonth(history.month, history.sales, history.past, time.Now().UTC())
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to forecast sales")
+		return
+	}
+	history.markPartial(c)
+	c.JSON(http.StatusOK, forecast)
+}
+
+func (s *server) fetchAnomalies(c *gin.Context) {
+	token, session, err := getSessionData(c)
+	if err != nil {
+		sessionExpired(c)
+		return
+	}
+
+	publisher := c.Param("publisher")
+
+	history, err := s.loadSalesHistory(c.Request.Context(), publisher, token, session)
+	if err != nil {
+		s.requestLogger(c).Errorw("Failed to load sales history", "error", err, "publisher", publisher)
+		failUnityRequest(c, err, "Failed to fetch sales history")
+		return
+	}
+
+	anomalies, err := analysis.DetectAnomalies(history.month, history.sales, history.past, time.Now().UTC())
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to detect anomalies")
+		return
+	}
+
+	// Anomalies against a partial history are shown but not notified, as the missing months may well explain them.
+	if len(history.missing) == 0 {
+		s.notifier.NotifyAsync(c.Request.Context(), publisher, anomalies)
+	}
+
+	history.markPartial(c)
+	c.JSON(http.StatusOK, anomalies)
+}
+
+func (s *server) requestLogger(c *gin.Context) logger.Logger {
+	return logger.ForContext(s.logger, c.Request.Context())
+}
+
+// sessionExpired answers 401 and marks the response, so the gateway can tell an expired Unity session from a rejected token.
+func sessionExpired(c *gin.Context) {
+	c.Header(session.ExpiredHeader, "true")
+	c.String(http.StatusUnauthorized, "Unity session expired")
+}
+
+// failUnityRequest answers 500 with the message, unless Unity failed the request because the session expired.
+func failUnityRequest(c *gin.Context, err error, message string) {
+	if errors.Is(err, api.ErrUnauthorized) {
+		sessionExpired(c)
+		return
+	}
+	c.String(http.StatusInternalServerError, message)
+}
+
 func getSessionData(c *gin.Context) (string, string, error) {
 	token, err := c.Cookie("kharma_token")
 	if err != nil {
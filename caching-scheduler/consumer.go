@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/envelope"
+	"github.com/Kwintenvdb/unity-publisher-management/common/events"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+	"github.com/Kwintenvdb/unity-publisher-management/common/messaging"
+	"github.com/Kwintenvdb/unity-publisher-management/common/tracing"
+)
+
+const (
+	retryBackoffMin = time.Second
+	retryBackoffMax = 30 * time.Second
+)
+
+// consumeAuthentications schedules the job of every login until the context is done. Logins that cannot be
+// read are moved to the dead-letter queue.
+func consumeAuthentications(ctx context.Context, subscriber messaging.Subscriber, keys *envelope.KeyRing, deadLetters *deadLetterQueue, log logger.Logger, schedule func(job schedulingJob)) {
+	for {
+		m, err := receive(ctx, subscriber, events.AuthenticationsTopic, log)
+		if err != nil {
+			return
+		}
+
+		msgCtx, span := tracing.StartConsume(messageContext(m), m)
+		msgLog := logger.ForContext(log, msgCtx)
+
+		job, err := decodeJob(keys, m)
+		if err != nil {
+			reason := refusalReason(err)
+			msgLog.Errorw("Refused scheduling job", "error", err, "reason", reason)
+			refusedJobs.WithLabelValues(reason).Inc()
+			tracing.RecordError(span, err)
+			span.End()
+			// Unless it was set aside, the message is received again after a restart.
+			if deadLetters.send(ctx, m, reason, err, msgLog) == nil {
+				ack(subscriber, m, msgLog)
+			}
+			continue
+		}
+		job.origin = span.SpanContext()
+
+		msgLog.Infow("Received scheduling job", "publisher", job.Publisher)
+		schedule(job)
+		span.End()
+		ack(subscriber, m, msgLog)
+	}
+}
+
+// consumeSessionExpiries stops caching sales of sessions the gateway found to be expired or logged out of.
+func consumeSessionExpiries(ctx context.Context, subscriber messaging.Subscriber, jobs *jobRegistry, deadLetters *deadLetterQueue, log logger.Logger) {
+	for {
+		m, err := receive(ctx, subscriber, events.SessionsEndedTopic, log)
+		if err != nil {
+			return
+		}
+
+		msgCtx, span := tracing.StartConsume(messageContext(m), m)
+		msgLog := logger.ForContext(log, msgCtx)
+
+		var expired events.SessionEnded
+		if _, err := events.Decode(m.Value, &expired); err != nil {
+			msgLog.Errorw("Failed to parse session expiry", "error", err)
+			tracing.RecordError(span, err)
+			span.End()
+			if deadLetters.send(ctx, m, refusalReason(err), err, msgLog) == nil {
+				ack(subscriber, m, msgLog)
+			}
+			continue
+		}
+		if jobs.expire(expired.Publisher, expired.Session, expired.Before) {
+			msgLog.Infow("Stopped caching sales of ended session", "publisher", expired.Publisher, "reason", expired.Reason)
+		}
+		span.End()
+		ack(subscriber, m, msgLog)
+	}
+}
+
+// receive waits for the next message of the subscriber. Failures, such as an unreachable broker, are retried
+// with backoff, so it only fails once the context is done or the subscriber is closed.
+func receive(ctx context.Context, subscriber messaging.Subscriber, topic string, log logger.Logger) (messaging.Message, error) {
+	var m messaging.Message
+	err := retry(ctx, func() error {
+		var err error
+		m, err = subscriber.Receive(ctx)
+		return err
+	}, func(err error, backoff time.Duration) {
+		log.Warnw("Failed to receive message, retrying", "error", err, "topic", topic, "backoff", backoff)
+		receiveErrors.WithLabelValues(topic).Inc()
+	})
+	return m, err
+}
+
+// retry calls attempt until it succeeds, backing off between failures, which are reported to failed.
+// It gives up once the context is done, and on messaging.ErrClosed.
+func retry(ctx context.Context, attempt func() error, failed func(err error, backoff time.Duration)) error {
+	backoff := retryBackoffMin
+	for {
+		err := attempt()
+		if err == nil || ctx.Err() != nil || errors.Is(err, messaging.ErrClosed) {
+			return err
+		}
+		failed(err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+		if backoff > retryBackoffMax {
+			backoff = retryBackoffMax
+		}
+	}
+}
+
+// ack acknowledges a handled message, refused ones included, so the group does not receive it again.
+// It does not take the consumer's context, so the message handled when shutting down is acknowledged as well.
+func ack(subscriber messaging.Subscriber, m messaging.Message, log logger.Logger) {
+	if err := subscriber.Ack(context.Background(), m); err != nil {
+		log.Errorw("Failed to acknowledge message", "error", err, "topic", m.Topic)
+	}
+}
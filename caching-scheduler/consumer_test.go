@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+	"github.com/Kwintenvdb/unity-publisher-management/common/envelope"
+	"github.com/Kwintenvdb/unity-publisher-management/common/events"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+	"github.com/Kwintenvdb/unity-publisher-management/common/messaging"
+	"github.com/Kwintenvdb/unity-publisher-management/common/session"
+)
+
+const testDeadLetterTopic = "test.dead-letters"
+
+func TestConsumeAuthentications(t *testing.T) {
+	broker := messaging.NewMemory()
+	keys := testKeys(t, "test")
+	log := logger.NewLogger()
+	deadLetters := broker.Subscribe(testDeadLetterTopic, "test")
+	queue := newDeadLetterQueue(testDeadLetterTopic, broker.Publisher(), log)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	jobs := make(chan schedulingJob, 1)
+	go consumeAuthentications(ctx, broker.Subscribe(events.AuthenticationsTopic, "test"), keys, queue, log, func(job schedulingJob) {
+		jobs <- job
+	})
+
+	secrets, err := keys.Seal(events.SessionSecrets{KharmaSession: "session", KharmaToken: "token"}, []byte("publisher"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(t, broker, events.UserAuthenticated{Publisher: "publisher", Secrets: secrets})
+
+	select {
+	case job := <-jobs:
+		if job.Publisher != "publisher" || job.KharmaSession != "session" || job.KharmaToken != "token" {
+			t.Errorf("job = %+v, want the session of publisher", job)
+		}
+		if job.authenticated.IsZero() {
+			t.Error("job has no authentication time")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no job scheduled")
+	}
+
+	otherKeys := testKeys(t, "other")
+	foreign, err := otherKeys.Seal(events.SessionSecrets{KharmaSession: "session"}, []byte("publisher"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := keys.Seal(events.SessionSecrets{KharmaSession: "session"}, []byte("other publisher"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	refusals := []struct {
+		name   string
+		value  []byte
+		reason string
+	}{
+		{"malformed", []byte("{"), "invalid"},
+		{"unencrypted", marshal(t, events.UserAuthenticated{Publisher: "publisher"}), "unencrypted"},
+		{"unknown key", marshal(t, events.UserAuthenticated{Publisher: "publisher", Secrets: foreign}), "unknown-key"},
+		{"other publisher", marshal(t, events.UserAuthenticated{Publisher: "publisher", Secrets: replayed}), "decrypt"},
+		{"wrong type", marshal(t, events.SessionEnded{Publisher: "publisher"}), "incompatible"},
+	}
+	for _, refusal := range refusals {
+		t.Run(refusal.name, func(t *testing.T) {
+			err := broker.Publisher().Publish(ctx, messaging.Message{Topic: events.AuthenticationsTopic, Key: []byte("key"), Value: refusal.value})
+			if err != nil {
+				t.Fatal(err)
+			}
+			letter := mustReceive(t, deadLetters)
+			if letter.Headers[deadLetterReasonHeader] != refusal.reason {
+				t.Errorf("reason = %q, want %q", letter.Headers[deadLetterReasonHeader], refusal.reason)
+			}
+			if letter.Headers[deadLetterTopicHeader] != events.AuthenticationsTopic || letter.Headers[deadLetterErrorHeader] == "" {
+				t.Errorf("headers = %v, want topic and error", letter.Headers)
+			}
+			if string(letter.Key) != "key" || string(letter.Value) != string(refusal.value) {
+				t.Errorf("dead letter %q: %q, want the refused message", letter.Key, letter.Value)
+			}
+		})
+	}
+	select {
+	case job := <-jobs:
+		t.Errorf("refused message scheduled %+v", job)
+	default:
+	}
+}
+
+func TestConsumeSessionExpiries(t *testing.T) {
+	loggedIn := time.Now().UTC()
+	afterLogin, beforeLogin := loggedIn.Add(time.Millisecond), loggedIn.Add(-time.Millisecond)
+	tests := []struct {
+		name   string
+		ended  events.SessionEnded
+		expire bool
+	}{
+		{"session", events.SessionEnded{Publisher: "publisher", Session: session.Fingerprint("session")}, true},
+		{"other session", events.SessionEnded{Publisher: "publisher", Session: session.Fingerprint("other")}, false},
+		{"other publisher", events.SessionEnded{Publisher: "other"}, false},
+		{"every session", events.SessionEnded{Publisher: "publisher"}, true},
+		{"every session before login", events.SessionEnded{Publisher: "publisher", Before: &afterLogin}, true},
+		{"every session after login", events.SessionEnded{Publisher: "publisher", Before: &beforeLogin}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			broker := messaging.NewMemory()
+			log := logger.NewLogger()
+			registry := newJobRegistry()
+			registry.put(schedulingJob{Publisher: "publisher", KharmaSession: "session", authenticated: loggedIn})
+			queue := newDeadLetterQueue(testDeadLetterTopic, broker.Publisher(), log)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			subscriber := &ackedSubscriber{Subscriber: broker.Subscribe(events.SessionsEndedTopic, "test"), acked: make(chan struct{}, 1)}
+			go consumeSessionExpiries(ctx, subscriber, registry, queue, log)
+			publish(t, broker, test.ended)
+			select {
+			case <-subscriber.acked:
+			case <-time.After(time.Second):
+				t.Fatal("session end not handled")
+			}
+
+			if expired := len(registry.all()) == 0; expired != test.expire {
+				t.Errorf("expired = %v, want %v", expired, test.expire)
+			}
+		})
+	}
+}
+
+func TestConsumeSessionEndBeforeLogin(t *testing.T) {
+	now := time.Now().UTC()
+	hourAgo, inAnHour := now.Add(-time.Hour), now.Add(time.Hour)
+	tests := []struct {
+		name     string
+		ended    events.SessionEnded
+		schedule bool
+	}{
+		{"session", events.SessionEnded{Publisher: "publisher", Session: session.Fingerprint("session")}, false},
+		{"other session", events.SessionEnded{Publisher: "publisher", Session: session.Fingerprint("other")}, true},
+		{"other publisher", events.SessionEnded{Publisher: "other", Session: session.Fingerprint("session")}, true},
+		{"every session after login", events.SessionEnded{Publisher: "publisher", Before: &inAnHour}, false},
+		{"every session before login", events.SessionEnded{Publisher: "publisher", Before: &hourAgo}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			broker := messaging.NewMemory()
+			keys := testKeys(t, "test")
+			log := logger.NewLogger()
+			registry := newJobRegistry()
+			queue := newDeadLetterQueue(testDeadLetterTopic, broker.Publisher(), log)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			expiries := &ackedSubscriber{Subscriber: broker.Subscribe(events.SessionsEndedTopic, "test"), acked: make(chan struct{}, 1)}
+			authentications := &ackedSubscriber{Subscriber: broker.Subscribe(events.AuthenticationsTopic, "test"), acked: make(chan struct{}, 1)}
+			go consumeSessionExpiries(ctx, expiries, registry, queue, log)
+			go consumeAuthentications(ctx, authentications, keys, queue, log, func(job schedulingJob) {
+				registry.put(job)
+			})
+
+			// The end of the session is received before its login.
+			publish(t, broker, test.ended)
+			waitForAck(t, expiries)
+			secrets, err := keys.Seal(events.SessionSecrets{KharmaSession: "session", KharmaToken: "token"}, []byte("publisher"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			publish(t, broker, events.UserAuthenticated{Publisher: "publisher", Secrets: secrets})
+			waitForAck(t, authentications)
+
+			if scheduled := len(registry.all()) == 1; scheduled != test.schedule {
+				t.Errorf("scheduled = %v, want %v", scheduled, test.schedule)
+			}
+		})
+	}
+}
+
+func TestConsumeSessionExpiriesDeadLetters(t *testing.T) {
+	broker := messaging.NewMemory()
+	log := logger.NewLogger()
+	deadLetters := broker.Subscribe(testDeadLetterTopic, "test")
+	queue := newDeadLetterQueue(testDeadLetterTopic, broker.Publisher(), log)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go consumeSessionExpiries(ctx, broker.Subscribe(events.SessionsEndedTopic, "test"), newJobRegistry(), queue, log)
+
+	if err := broker.Publisher().Publish(ctx, messaging.Message{Topic: events.SessionsEndedTopic, Value: []byte("not json")}); err != nil {
+		t.Fatal(err)
+	}
+	letter := mustReceive(t, deadLetters)
+	if letter.Headers[deadLetterTopicHeader] != events.SessionsEndedTopic || letter.Headers[deadLetterReasonHeader] != "invalid" {
+		t.Errorf("headers = %v, want topic and reason invalid", letter.Headers)
+	}
+}
+
+func publish(t *testing.T, broker messaging.Broker, payload events.Payload) {
+	t.Helper()
+	topic, err := events.Topic(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Publisher().Publish(context.Background(), messaging.Message{Topic: topic, Value: marshal(t, payload)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func marshal(t *testing.T, payload events.Payload) []byte {
+	t.Helper()
+	value, err := events.Marshal(config.Gateway, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func mustReceive(t *testing.T, subscriber messaging.Subscriber) messaging.Message {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	m, err := subscriber.Receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// ackedSubscriber reports every acknowledged message.
+type ackedSubscriber struct {
+	messaging.Subscriber
+	acked chan struct{}
+}
+
+func (s *ackedSubscriber) Ack(ctx context.Context, m messaging.Message) error {
+	s.acked <- struct{}{}
+	return s.Subscriber.Ack(ctx, m)
+}
+
+func waitForAck(t *testing.T, subscriber *ackedSubscriber) {
+	t.Helper()
+	select {
+	case <-subscriber.acked:
+	case <-time.After(time.Second):
+		t.Fatal("message not handled")
+	}
+}
+
+func testKeys(t *testing.T, id string) *envelope.KeyRing {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), id+".key")
+	if err := os.WriteFile(file, []byte(base64.StdEncoding.EncodeToString(key)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := envelope.Load([]config.EncryptionKeyConfig{{ID: id, File: file}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+	"github.com/Kwintenvdb/unity-publisher-management/common/messaging"
+	"github.com/Kwintenvdb/unity-publisher-management/common/requestid"
+)
+
+// Headers a dead letter carries along with those of the message it holds.
+const (
+	deadLetterIDHeader     = "dead-letter-id"
+	deadLetterTopicHeader  = "dead-letter-topic"
+	deadLetterReasonHeader = "dead-letter-reason"
+	deadLetterErrorHeader  = "dead-letter-error"
+	deadLetterTimeHeader   = "dead-letter-time"
+	// Marks a message of the dead-letter topic as the resolution of the dead letter of the given id.
+	deadLetterResolvedHeader = "dead-letter-resolved"
+)
+
+var errDeadLetterNotFound = errors.New("dead letter not found")
+
+// deadLetter is a message the scheduler could not handle. Its value is not shown, as a message that could not
+// be read may hold a Unity session in the clear; its size and hash tell dead letters apart.
+type deadLetter struct {
+	ID     string `json:"id"`
+	Topic  string `json:"topic"`
+	Key    string `json:"key"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+	// The refusal reason, as in the refused jobs metric.
+	Reason   string    `json:"reason"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failedAt"`
+
+	// The message as it was refused, to replay it.
+	message messaging.Message
+}
+
+// deadLetterQueue publishes the messages the consumers cannot handle to the dead-letter topic, so they
+// neither stop the consumers nor get lost. It reads the topic back to hold the dead letters that were not yet
+// replayed or discarded; replaying or discarding one publishes its resolution to the topic as well.
+type deadLetterQueue struct {
+	topic     string
+	publisher messaging.Publisher
+	logger    logger.Logger
+
+	mutex   sync.Mutex
+	letters map[string]*deadLetter
+}
+
+func newDeadLetterQueue(topic string, publisher messaging.Publisher, log logger.Logger) *deadLetterQueue {
+	return &deadLetterQueue{
+		topic:     topic,
+		publisher: publisher,
+		logger:    log,
+		letters:   make(map[string]*deadLetter),
+	}
+}
+
+// send publishes the message to the dead-letter topic with the reason and error it was refused with.
+// Failures are retried until it is published or the context is done; the message must not be acknowledged
+// if it was not.
+func (q *deadLetterQueue) send(ctx context.Context, m messaging.Message, reason string, cause error, log logger.Logger) error {
+	headers := make(messaging.Headers, len(m.Headers)+5)
+	for key, value := range m.Headers {
+		headers[key] = value
+	}
+	headers[deadLetterIDHeader] = requestid.New()
+	headers[deadLetterTopicHeader] = m.Topic
+	headers[deadLetterReasonHeader] = reason
+	headers[deadLetterErrorHeader] = cause.Error()
+	headers[deadLetterTimeHeader] = time.Now().UTC().Format(time.RFC3339Nano)
+	letter := messaging.Message{Topic: q.topic, Key: m.Key, Value: m.Value, Headers: headers}
+
+	err := retry(ctx, func() error {
+		return q.publisher.Publish(ctx, letter)
+	}, func(err error, backoff time.Duration) {
+		log.Warnw("Failed to publish dead letter, retrying", "error", err, "backoff", backoff)
+	})
+	if err != nil {
+		return err
+	}
+	deadLetters.WithLabelValues(m.Topic, reason).Inc()
+	log.Warnw("Moved message to dead-letter topic", "id", headers[deadLetterIDHeader], "topic", m.Topic, "reason", reason)
+	return nil
+}
+
+// consume holds the dead letters of the topic until they are resolved. It reads the whole topic on every
+// start, so the dead letters survive restarts for as long as the topic retains them.
+func (q *deadLetterQueue) consume(ctx context.Context, browser messaging.Subscriber) {
+	for {
+		m, err := receive(ctx, browser, q.topic, q.logger)
+		if err != nil {
+			return
+		}
+		q.mutex.Lock()
+		if id, ok := m.Headers[deadLetterResolvedHeader]; ok {
+			delete(q.letters, id)
+		} else if id, ok := m.Headers[deadLetterIDHeader]; ok {
+			failedAt, _ := time.Parse(time.RFC3339Nano, m.Headers[deadLetterTimeHeader])
+			hash := sha256.Sum256(m.Value)
+			q.letters[id] = &deadLetter{
+				ID:       id,
+				Topic:    m.Headers[deadLetterTopicHeader],
+				Key:      string(m.Key),
+				Size:     len(m.Value),
+				SHA256:   hex.EncodeToString(hash[:]),
+				Reason:   m.Headers[deadLetterReasonHeader],
+				Error:    m.Headers[deadLetterErrorHeader],
+				FailedAt: failedAt,
+				message:  m,
+			}
+		}
+		q.mutex.Unlock()
+	}
+}
+
+// list returns the unresolved dead letters, oldest first.
+func (q *deadLetterQueue) list() []deadLetter {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	letters := make([]deadLetter, 0, len(q.letters))
+	for _, letter := range q.letters {
+		letters = append(letters, *letter)
+	}
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].FailedAt.Before(letters[j].FailedAt)
+	})
+	return letters
+}
+
+// replay publishes the message of the dead letter to its topic again, for instance after the key it was
+// encrypted with was added, and resolves the dead letter. A message that fails again becomes a new dead letter.
+func (q *deadLetterQueue) replay(ctx context.Context, id string) error {
+	letter, err := q.get(id)
+	if err != nil {
+		return err
+	}
+	headers := make(messaging.Headers, len(letter.message.Headers))
+	for key, value := range letter.message.Headers {
+		headers[key] = value
+	}
+	for _, key := range []string{deadLetterIDHeader, deadLetterTopicHeader, deadLetterReasonHeader, deadLetterErrorHeader, deadLetterTimeHeader} {
+		delete(headers, key)
+	}
+	original := messaging.Message{Topic: letter.Topic, Key: letter.message.Key, Value: letter.message.Value, Headers: headers}
+	if err := q.publisher.Publish(ctx, original); err != nil {
+		return err
+	}
+	return q.resolve(ctx, letter, "replayed")
+}
+
+// discard resolves the dead letter without replaying it.
+func (q *deadLetterQueue) discard(ctx context.Context, id string) error {
+	letter, err := q.get(id)
+	if err != nil {
+		return err
+	}
+	return q.resolve(ctx, letter, "discarded")
+}
+
+func (q *deadLetterQueue) get(id string) (*deadLetter, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	letter, ok := q.letters[id]
+	if !ok {
+		return nil, errDeadLetterNotFound
+	}
+	return letter, nil
+}
+
+// resolve publishes the resolution of the dead letter, so it is not held again after a restart.
+func (q *deadLetterQueue) resolve(ctx context.Context, letter *deadLetter, resolution string) error {
+	marker := messaging.Message{
+		Topic:   q.topic,
+		Key:     letter.message.Key,
+		Headers: messaging.Headers{deadLetterResolvedHeader: letter.ID},
+	}
+	if err := q.publisher.Publish(ctx, marker); err != nil {
+		return err
+	}
+	q.mutex.Lock()
+	delete(q.letters, letter.ID)
+	q.mutex.Unlock()
+	resolvedDeadLetters.WithLabelValues(resolution).Inc()
+	q.logger.Infow("Resolved dead letter", "id", letter.ID, "topic", letter.Topic, "resolution", resolution)
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/events"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+	"github.com/Kwintenvdb/unity-publisher-management/common/messaging"
+)
+
+func TestDeadLettersHideValues(t *testing.T) {
+	broker := messaging.NewMemory()
+	queue := newDeadLetterQueue(testDeadLetterTopic, broker.Publisher(), logger.NewLogger())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go queue.consume(ctx, broker.Browse(testDeadLetterTopic))
+
+	value := []byte(`{"kharmaSession":"secret session","kharmaToken":"secret token"}`)
+	m := messaging.Message{Topic: events.AuthenticationsTopic, Key: []byte("key"), Value: value}
+	if err := queue.send(ctx, m, "unencrypted", errUnencrypted, queue.logger); err != nil {
+		t.Fatal(err)
+	}
+	letters := waitForDeadLetters(t, queue, 1)
+
+	hash := sha256.Sum256(value)
+	letter := letters[0]
+	if letter.Size != len(value) || letter.SHA256 != hex.EncodeToString(hash[:]) {
+		t.Errorf("size %d and hash %s, want %d and %x", letter.Size, letter.SHA256, len(value), hash)
+	}
+	listed, err := json.Marshal(letters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(listed), "secret") {
+		t.Errorf("listed %s, want no session", listed)
+	}
+
+	// Replaying still publishes the message as it was refused.
+	subscriber := broker.Subscribe(events.AuthenticationsTopic, "test")
+	if err := queue.replay(ctx, letter.ID); err != nil {
+		t.Fatal(err)
+	}
+	if replayed := mustReceive(t, subscriber); string(replayed.Value) != string(value) || string(replayed.Key) != "key" {
+		t.Errorf("replayed %q: %q, want the refused message", replayed.Key, replayed.Value)
+	}
+	waitForDeadLetters(t, queue, 0)
+}
+
+func waitForDeadLetters(t *testing.T, queue *deadLetterQueue, count int) []deadLetter {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		letters := queue.list()
+		if len(letters) == count {
+			return letters
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d dead letters, want %d", len(letters), count)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/session"
+)
+
+// How long the end of a session is remembered. Logins and session ends are consumed from different topics,
+// so the login of a session may be received after its end; it is not scheduled if received within this time.
+const endedSessionLifetime = 24 * time.Hour
+
+// jobRegistry holds the scheduled job of every publisher. It is shared by the consumers and the caching runs.
+type jobRegistry struct {
+	mutex sync.Mutex
+	jobs  map[string]schedulingJob
+	// When single sessions ended, by publisher and fingerprint.
+	endedSessions map[string]time.Time
+	// The latest time every session of a publisher ended, by publisher.
+	endedBefore map[string]time.Time
+}
+
+func newJobRegistry() *jobRegistry {
+	return &jobRegistry{
+		jobs:          make(map[string]schedulingJob),
+		endedSessions: make(map[string]time.Time),
+		endedBefore:   make(map[string]time.Time),
+	}
+}
+
+// put schedules the job, replacing an earlier job of the publisher, unless its session already ended.
+// It returns the number of scheduled jobs, and whether the job was scheduled.
+func (r *jobRegistry) put(job schedulingJob) (int, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ended := r.endedSessions[job.Publisher+"/"+session.Fingerprint(job.KharmaSession)]; ended {
+		return len(r.jobs), false
+	}
+	if before, ended := r.endedBefore[job.Publisher]; ended && job.authenticated.Before(before) {
+		return len(r.jobs), false
+	}
+	r.jobs[job.Publisher] = job
+	scheduledJobsGauge.Set(float64(len(r.jobs)))
+	return len(r.jobs), true
+}
+
+// expire removes the job of the publisher if it uses the session of the given fingerprint.
+// An empty fingerprint removes the job regardless of its session, unless the publisher logged in at or
+// after the given time, if one is given. It reports whether a job was removed.
+// The end of a session with a fingerprint or time is remembered, so put refuses its login if received later.
+func (r *jobRegistry) expire(publisher, fingerprint string, before *time.Time) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.forgetEndedSessions(time.Now())
+	switch {
+	case fingerprint != "":
+		r.endedSessions[publisher+"/"+fingerprint] = time.Now()
+	case before != nil && before.After(r.endedBefore[publisher]):
+		r.endedBefore[publisher] = *before
+	}
+
+	job, ok := r.jobs[publisher]
+	if !ok || (fingerprint != "" && session.Fingerprint(job.KharmaSession) != fingerprint) {
+		return false
+	}
+	if before != nil && !job.authenticated.Before(*before) {
+		return false
+	}
+	delete(r.jobs, publisher)
+	scheduledJobsGauge.Set(float64(len(r.jobs)))
+	return true
+}
+
+// forgetEndedSessions drops the ends of sessions older than endedSessionLifetime.
+func (r *jobRegistry) forgetEndedSessions(now time.Time) {
+	for key, ended := range r.endedSessions {
+		if now.Sub(ended) > endedSessionLifetime {
+			delete(r.endedSessions, key)
+		}
+	}
+	for publisher, before := range r.endedBefore {
+		if now.Sub(before) > endedSessionLifetime {
+			delete(r.endedBefore, publisher)
+		}
+	}
+}
+
+func (r *jobRegistry) all() []schedulingJob {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	jobs := make([]schedulingJob, 0, len(r.jobs))
+	for _, job := range r.jobs {
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-co-op/gocron"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+	"github.com/Kwintenvdb/unity-publisher-management/common/messaging"
+)
+
+// shutdown stops consuming, lets the running caching run finish and stops the HTTP server last
+// so probes and metrics stay available while draining. Everything shares one deadline.
+func shutdown(srv *http.Server, scheduler *gocron.Scheduler, subscribers []messaging.Subscriber, timeout time.Duration, log logger.Logger) {
+	log.Infow("Shutting down...", "timeout", timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Closing the subscribers leaves the consumer group. Handled messages were acknowledged already.
+	for _, subscriber := range subscribers {
+		if err := subscriber.Close(); err != nil {
+			log.Errorw("Failed to close subscriber", "error", err)
+		}
+	}
+
+	// Stop waits for running jobs to finish.
+	stopped := make(chan struct{})
+	go func() {
+		scheduler.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Warn("Timed out waiting for running jobs")
+	}
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Errorw("Failed to stop HTTP server", "error", err)
+		return
+	}
+	log.Info("Shutdown complete")
+}
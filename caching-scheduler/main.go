@@ -4,66 +4,128 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/go-co-op/gocron"
-	kafka "github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/cache"
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+	"github.com/Kwintenvdb/unity-publisher-management/common/envelope"
+	"github.com/Kwintenvdb/unity-publisher-management/common/events"
+	"github.com/Kwintenvdb/unity-publisher-management/common/jwtauth"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+	"github.com/Kwintenvdb/unity-publisher-management/common/messaging"
+	"github.com/Kwintenvdb/unity-publisher-management/common/requestid"
+	"github.com/Kwintenvdb/unity-publisher-management/common/session"
+	"github.com/Kwintenvdb/unity-publisher-management/common/tracing"
 )
 
+// errSessionExpired is returned by runs whose Unity session expired. Their job cannot run again.
+var errSessionExpired = errors.New("unity session expired")
+
+var errUnencrypted = errors.New("unity session is not encrypted")
+
+var tracer = tracing.Tracer("github.com/Kwintenvdb/unity-publisher-management/caching-scheduler")
+
 type schedulingJob struct {
-	Publisher     string `json:"publisher"`
-	KharmaSession string `json:"kharmaSession"`
-	KharmaToken   string `json:"kharmaToken"`
-	JWT           string `json:"jwt"`
+	Publisher     string
+	KharmaSession string
+	KharmaToken   string
+
+	// Span of the message that scheduled the job. Every caching run links to it.
+	origin trace.SpanContext
+	// When the gateway published the login of the job.
+	authenticated time.Time
 }
 
 func main() {
-	scheduledJobs := map[string]schedulingJob{}
+	cfg, err := config.Load(config.Scheduler)
+	if err != nil {
+		panic(err)
+	}
 
-	scheduler := gocron.NewScheduler(time.UTC)
-	scheduler.Every(5).Minutes().Do(func() {
-		println("Scheduled task running...")
-		println("Nr of scheduled jobs: ", len(scheduledJobs))
+	log := logger.NewLogger()
+	defer log.Sync()
 
-		for _, job := range scheduledJobs {
-			fetchData(job)
-		}
-	})
+	shutdownTracing, err := tracing.Setup(config.Scheduler, cfg.Tracing)
+	if err != nil {
+		panic(err)
+	}
 
-	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers: []string{"localhost:61162"},
-		Topic:   "user.authentications",
-		GroupID: "caching-scheduler",
-	})
-	defer reader.Close()
+	// The scheduler calls the api-service and caching service with tokens of its own.
+	signer, err := jwtauth.NewSigner(config.Scheduler, cfg.Scheduler.IdentityKey)
+	if err != nil {
+		panic(err)
+	}
+
+	// The gateway encrypts the Unity sessions with these keys.
+	messageKeys, err := envelope.Load(cfg.Kafka.EncryptionKeys)
+	if err != nil {
+		panic(err)
+	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	println("Waiting for messages from user.authentications topic...")
+	scheduledJobs := newJobRegistry()
 
-	// Poll for new messages from user.authentications topic
-	for {
-		m, err := reader.ReadMessage(context.Background())
-		if err != nil {
-			panic(err)
-		}
+	broker := messaging.New(cfg.Kafka)
+	// Messages the consumers cannot handle are set aside instead of stopping them.
+	deadLetters := newDeadLetterQueue(cfg.Scheduler.DeadLetterTopic, broker.Publisher(), log)
+	deadLettersBrowser := broker.Browse(cfg.Scheduler.DeadLetterTopic)
+	go deadLetters.consume(ctx, deadLettersBrowser)
 
-		var job schedulingJob
-		err = json.Unmarshal(m.Value, &job)
-		if err != nil {
-			panic(err)
+	srv := startHTTPServer(cfg, broker, deadLetters, log)
+
+	scheduler := gocron.NewScheduler(time.UTC)
+	scheduler.Every(cfg.Scheduler.Interval).Do(func() {
+		jobs := scheduledJobs.all()
+		log.Infow("Scheduled task running", "jobs", len(jobs))
+
+		for _, job := range jobs {
+			if err := fetchData(cfg, log, signer, job); errors.Is(err, errSessionExpired) {
+				scheduledJobs.expire(job.Publisher, session.Fingerprint(job.KharmaSession), nil)
+				log.Infow("Stopped caching sales of expired session", "publisher", job.Publisher)
+			}
 		}
+	})
+
+	expiries := broker.Subscribe(events.SessionsEndedTopic, cfg.Scheduler.GroupID)
+	go consumeSessionExpiries(ctx, expiries, scheduledJobs, deadLetters, log)
 
-		println("Received scheduling job for publisher", job.Publisher)
+	authentications := broker.Subscribe(events.AuthenticationsTopic, cfg.Scheduler.GroupID)
 
-		scheduledJobs[job.Publisher] = job
-		if len(scheduledJobs) == 1 {
+	log.Info("Waiting for messages from user.authentications topic...")
+	consumeAuthentications(ctx, authentications, messageKeys, deadLetters, log, func(job schedulingJob) {
+		scheduled, ok := scheduledJobs.put(job)
+		if !ok {
+			log.Infow("Ignored login of ended session", "publisher", job.Publisher)
+			return
+		}
+		if scheduled == 1 {
 			// Won't start if already started
 			scheduler.StartAsync()
 			scheduler.RunAll()
 		}
+	})
+
+	shutdown(srv, scheduler, []messaging.Subscriber{authentications, expiries, deadLettersBrowser}, cfg.ShutdownTimeout, log)
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		log.Errorw("Failed to flush traces", "error", err)
 	}
 }
 
@@ -73,6 +135,7 @@ type MonthData struct {
 }
 
 type SalesData struct {
+	PackageId   string `json:"package_id"`
 	PackageName string `json:"package_name"`
 	Price       string `json:"price"`
 	Sales       int    `json:"sales"`
@@ -80,64 +143,231 @@ type SalesData struct {
 	LastSale    string `json:"last_sale"`
 }
 
-func fetchData(job schedulingJob) {
-	println("Fetching months...")
+// decodeJob decrypts the Unity session of a UserAuthenticated event. Events of versions the scheduler cannot
+// read, encrypted with a key it does not hold, or not encrypted at all, are refused.
+func decodeJob(keys *envelope.KeyRing, m messaging.Message) (schedulingJob, error) {
+	var auth events.UserAuthenticated
+	event, err := events.Decode(m.Value, &auth)
+	if err != nil {
+		return schedulingJob{}, err
+	}
+	if auth.Secrets == nil {
+		return schedulingJob{}, errUnencrypted
+	}
+	// The secrets are bound to the publisher, so they cannot be replayed for another one.
+	var secrets events.SessionSecrets
+	if err := keys.Open(auth.Secrets, []byte(auth.Publisher), &secrets); err != nil {
+		return schedulingJob{}, err
+	}
+	return schedulingJob{
+		Publisher:     auth.Publisher,
+		KharmaSession: secrets.KharmaSession,
+		KharmaToken:   secrets.KharmaToken,
+		authenticated: event.Time,
+	}, nil
+}
 
-	client := http.Client{}
+// refusalReason labels the error of decodeJob, or of decoding any other event, for the refused jobs metric
+// and dead letters.
+func refusalReason(err error) string {
+	switch {
+	case errors.Is(err, envelope.ErrUnknownKey):
+		return "unknown-key"
+	case errors.Is(err, envelope.ErrDecrypt):
+		return "decrypt"
+	case errors.Is(err, errUnencrypted):
+		return "unencrypted"
+	case errors.Is(err, events.ErrIncompatible), errors.Is(err, events.ErrWrongType):
+		return "incompatible"
+	default:
+		return "invalid"
+	}
+}
 
-	req := createRequest(fmt.Sprintf("http://localhost:8081/api/months/%s", job.Publisher), job)
-	res, err := client.Do(req)
-	if err != nil {
-		println("Failed to fetch months")
-		return
+// messageContext returns a context carrying the request id from the headers of a message.
+func messageContext(m messaging.Message) context.Context {
+	if id, ok := m.Headers[requestid.Header]; ok {
+		return requestid.NewContext(context.Background(), id)
 	}
+	return context.Background()
+}
+
+// fetchData caches the sales of every month of the job. Failures are logged and recorded.
+// A run that could not fetch the months returns the error, e.g. errSessionExpired.
+func fetchData(cfg *config.Config, log logger.Logger, signer *jwtauth.Signer, job schedulingJob) error {
+	// Every run gets its own request id so its calls can be followed through the other services.
+	// Runs are traces of their own that link back to the message that scheduled the job.
+	ctx := requestid.NewContext(context.Background(), requestid.New())
+	ctx, span := tracer.Start(ctx, "caching run",
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.Link{SpanContext: job.origin}),
+		trace.WithAttributes(attribute.String("publisher", job.Publisher)),
+	)
+	defer span.End()
+	log = logger.ForContext(log, ctx).With("publisher", job.Publisher)
+
+	log.Debug("Fetching months...")
+
+	client := http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
 
 	var months []MonthData
-	err = json.NewDecoder(res.Body).Decode(&months)
+	err := getJson(ctx, &client, signer, fmt.Sprintf("http://%s/api/months/%s", cfg.ApiService.Host, job.Publisher), job, &months)
 	if err != nil {
-		println("Failed to parse months")
-		return
+		log.Errorw("Failed to fetch months", "error", err)
+		recordFailure(job, "months")
+		jobRuns.WithLabelValues("failure").Inc()
+		tracing.RecordError(span, err)
+		return err
 	}
 
 	// Fetch sales
-	println("Fetching sales...")
+	log.Debug("Fetching sales...")
+	fresh := freshMonths(ctx, cfg, log, signer, job, &client)
+	var wg sync.WaitGroup
+	var failed int32
 	for _, month := range months {
-		go fetchAndCacheSales(job, month, &client)
+		if fresh[month.Value] {
+			log.Debugw("Skipping month the gateway cached since the last run", "month", month.Value)
+			continue
+		}
+		wg.Add(1)
+		go func(month MonthData) {
+			defer wg.Done()
+			if !fetchAndCacheSales(ctx, cfg, log, signer, job, month, &client) {
+				atomic.StoreInt32(&failed, 1)
+			}
+		}(month)
+	}
+	wg.Wait()
+
+	if failed == 0 {
+		jobRuns.WithLabelValues("success").Inc()
+		lastSuccess.WithLabelValues(job.Publisher).SetToCurrentTime()
+	} else {
+		jobRuns.WithLabelValues("failure").Inc()
 	}
+
+	// The api-service publishes any anomalies it finds as events, so the response itself is not needed.
+	detectAnomalies(ctx, cfg, log, signer, job, &client)
+	return nil
 }
 
-// TODO extract some duplicate code
-func fetchAndCacheSales(job schedulingJob, month MonthData, client *http.Client) {
-	req := createRequest(fmt.Sprintf("http://%s/api/sales/%s/%s", getApiServiceHost(), job.Publisher, month.Value), job)
+// freshMonths returns the months of the job the gateway wrote to the cache within the last interval.
+// Their sales were fetched from Unity moments ago, so fetching them again would only repeat the same work.
+// If the provenance cannot be fetched, every month is refreshed.
+func freshMonths(ctx context.Context, cfg *config.Config, log logger.Logger, signer *jwtauth.Signer, job schedulingJob, client *http.Client) map[string]bool {
+	token, err := signer.Token(config.CachingService, "")
+	if err != nil {
+		log.Warnw("Failed to sign service token", "error", err)
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s/provenance/%s", cfg.CachingService.Host, job.Publisher), nil)
+	if err != nil {
+		log.Warnw("Failed to fetch cache provenance", "error", err)
+		return nil
+	}
+	req.Header.Set(requestid.Header, requestid.FromContext(ctx))
+	jwtauth.SetBearer(req, token)
+
+	var provenance map[string]cache.Provenance
 	res, err := client.Do(req)
+	if err == nil {
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			err = fmt.Errorf("unexpected status code: %d", res.StatusCode)
+		} else {
+			err = json.NewDecoder(res.Body).Decode(&provenance)
+		}
+	}
+	if err != nil {
+		log.Warnw("Failed to fetch cache provenance", "error", err)
+		return nil
+	}
+
+	fresh := make(map[string]bool)
+	for month, p := range provenance {
+		if p.Source == string(config.Gateway) && time.Since(p.Updated) < cfg.Scheduler.Interval {
+			fresh[month] = true
+		}
+	}
+	return fresh
+}
+
+func detectAnomalies(ctx context.Context, cfg *config.Config, log logger.Logger, signer *jwtauth.Signer, job schedulingJob, client *http.Client) {
+	log.Debug("Detecting anomalies...")
+
+	var anomalies json.RawMessage
+	err := getJson(ctx, client, signer, fmt.Sprintf("http://%s/api/anomalies/%s", cfg.ApiService.Host, job.Publisher), job, &anomalies)
 	if err != nil {
-		println("Failed to fetch sales")
-		return
+		log.Errorw("Failed to detect anomalies", "error", err)
 	}
+}
+
+// TODO extract some duplicate code
+func fetchAndCacheSales(ctx context.Context, cfg *config.Config, log logger.Logger, signer *jwtauth.Signer, job schedulingJob, month MonthData, client *http.Client) bool {
+	log = log.With("month", month.Value)
+
+	ctx, span := tracer.Start(ctx, "fetch and cache sales", trace.WithAttributes(attribute.String("month", month.Value)))
+	defer span.End()
 
 	var sales []SalesData
-	err = json.NewDecoder(res.Body).Decode(&sales)
+	err := getJson(ctx, client, signer, fmt.Sprintf("http://%s/api/sales/%s/%s", cfg.ApiService.Host, job.Publisher, month.Value), job, &sales)
 	if err != nil {
-		println("Failed to parse sales")
-		return
+		log.Errorw("Failed to fetch sales", "error", err)
+		recordFailure(job, "sales")
+		tracing.RecordError(span, err)
+		return false
 	}
 
-	println("Fetched sales for month", month.Value)
+	log.Debug("Fetched sales")
 
 	// Cache the sales
-	println("Caching sales...")
+	log.Debug("Caching sales...")
 
-	cacheUrl := fmt.Sprintf("http://%s/sales/%s/%s", getCachingServiceHost(), job.Publisher, month.Value)
+	cacheUrl := fmt.Sprintf("http://%s/sales/%s/%s", cfg.CachingService.Host, job.Publisher, month.Value)
+
+	token, err := signer.Token(config.CachingService, "")
+	if err != nil {
+		log.Errorw("Failed to sign service token", "error", err)
+		recordFailure(job, "cache")
+		tracing.RecordError(span, err)
+		return false
+	}
 
 	salesData, _ := json.Marshal(sales)
-	_, err = http.Post(cacheUrl, "application/json", bytes.NewReader(salesData))
+	cacheReq, _ := http.NewRequestWithContext(ctx, http.MethodPost, cacheUrl, bytes.NewReader(salesData))
+	cacheReq.Header.Set("Content-Type", "application/json")
+	cacheReq.Header.Set(requestid.Header, requestid.FromContext(ctx))
+	jwtauth.SetBearer(cacheReq, token)
+	cacheRes, err := client.Do(cacheReq)
+	if err == nil {
+		cacheRes.Body.Close()
+		if cacheRes.StatusCode != http.StatusOK {
+			err = fmt.Errorf("unexpected status code: %d", cacheRes.StatusCode)
+		}
+	}
 	if err != nil {
-		println("Failed to cache sales")
+		log.Errorw("Failed to cache sales", "error", err)
+		recordFailure(job, "cache")
+		tracing.RecordError(span, err)
+		return false
 	}
+	return true
 }
 
-func createRequest(url string, job schedulingJob) *http.Request {
-	req, _ := http.NewRequest(http.MethodGet, url, nil)
+// getJson calls the api-service on behalf of the publisher of the job and decodes the response into v.
+func getJson(ctx context.Context, client *http.Client, signer *jwtauth.Signer, url string, job schedulingJob, v interface{}) error {
+	token, err := signer.Token(config.ApiService, job.Publisher)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(requestid.Header, requestid.FromContext(ctx))
+	jwtauth.SetBearer(req, token)
 	req.AddCookie(&http.Cookie{
 		Name:  "kharma_token",
 		Value: job.KharmaToken,
@@ -146,23 +376,18 @@ func createRequest(url string, job schedulingJob) *http.Request {
 		Name:  "kharma_session",
 		Value: job.KharmaSession,
 	})
-	req.AddCookie(&http.Cookie{
-		Name:  "jwt",
-		Value: job.JWT,
-	})
-	return req
-}
 
-func getApiServiceHost() string {
-	if host, found := os.LookupEnv("UPM_API_SERVICE"); found {
-		return host
+	res, err := client.Do(req)
+	if err != nil {
+		return err
 	}
-	return "localhost:8081"
-}
+	defer res.Body.Close()
 
-func getCachingServiceHost() string {
-	if host, found := os.LookupEnv("UPM_CACHING_SERVICE"); found {
-		return host
+	if res.StatusCode == http.StatusUnauthorized && res.Header.Get(session.ExpiredHeader) != "" {
+		return errSessionExpired
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
 	}
-	return "localhost:8082"
+	return json.NewDecoder(res.Body).Decode(v)
 }
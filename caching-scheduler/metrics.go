@@ -0,0 +1,61 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	scheduledJobsGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "upm",
+		Subsystem: "scheduler",
+		Name:      "jobs",
+		Help:      "Number of publishers whose sales are being cached.",
+	})
+	jobRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "upm",
+		Subsystem: "scheduler",
+		Name:      "job_runs_total",
+		Help:      "Completed caching runs by result (success or failure).",
+	}, []string{"result"})
+	lastSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "upm",
+		Subsystem: "scheduler",
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix time of the last run that cached every month of a publisher.",
+	}, []string{"publisher"})
+	jobFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "upm",
+		Subsystem: "scheduler",
+		Name:      "failures_total",
+		Help:      "Failed steps of caching runs by publisher and stage (months, sales or cache).",
+	}, []string{"publisher", "stage"})
+	refusedJobs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "upm",
+		Subsystem: "scheduler",
+		Name:      "refused_jobs_total",
+		Help:      "Scheduling jobs refused by reason (incompatible, unknown-key, decrypt, unencrypted or invalid).",
+	}, []string{"reason"})
+	deadLetters = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "upm",
+		Subsystem: "scheduler",
+		Name:      "dead_letters_total",
+		Help:      "Messages moved to the dead-letter topic by topic and refusal reason.",
+	}, []string{"topic", "reason"})
+	resolvedDeadLetters = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "upm",
+		Subsystem: "scheduler",
+		Name:      "dead_letters_resolved_total",
+		Help:      "Dead letters resolved through the admin endpoints by resolution (replayed or discarded).",
+	}, []string{"resolution"})
+	receiveErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "upm",
+		Subsystem: "scheduler",
+		Name:      "receive_errors_total",
+		Help:      "Failures to receive messages by topic. Receiving is retried with backoff.",
+	}, []string{"topic"})
+)
+
+func recordFailure(job schedulingJob, stage string) {
+	jobFailures.WithLabelValues(job.Publisher, stage).Inc()
+}
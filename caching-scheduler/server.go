@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+	"github.com/Kwintenvdb/unity-publisher-management/common/health"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+	"github.com/Kwintenvdb/unity-publisher-management/common/messaging"
+)
+
+// startHTTPServer serves the probes and metrics of the scheduler, and the admin endpoints if an admin token
+// is configured.
+func startHTTPServer(cfg *config.Config, broker messaging.Broker, deadLetters *deadLetterQueue, log logger.Logger) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", health.Healthz)
+	mux.HandleFunc("/readyz", health.Readyz(health.Check{Name: "kafka", Check: broker.Ping}))
+
+	if cfg.Scheduler.AdminToken != "" {
+		admin := requireAdmin(cfg.Scheduler.AdminToken)
+		mux.Handle("/admin/dead-letters", admin(listDeadLetters(deadLetters)))
+		mux.Handle("/admin/dead-letters/", admin(resolveDeadLetter(deadLetters, log)))
+	}
+
+	srv := &http.Server{
+		Addr:    cfg.Scheduler.Addr,
+		Handler: mux,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalw("HTTP server failed", "error", err)
+		}
+	}()
+	return srv
+}
+
+// requireAdmin only lets requests with the admin token as bearer token through.
+func requireAdmin(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bearer, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+				writeStatus(w, http.StatusUnauthorized, map[string]interface{}{"code": http.StatusUnauthorized, "message": "invalid admin token"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// listDeadLetters serves GET /admin/dead-letters with the unresolved dead letters, oldest first.
+func listDeadLetters(deadLetters *deadLetterQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeStatus(w, http.StatusMethodNotAllowed, map[string]interface{}{"code": http.StatusMethodNotAllowed, "message": "method not allowed"})
+			return
+		}
+		writeStatus(w, http.StatusOK, deadLetters.list())
+	}
+}
+
+// resolveDeadLetter serves POST /admin/dead-letters/:id/replay, which publishes the message to its topic again,
+// and POST /admin/dead-letters/:id/discard.
+func resolveDeadLetter(deadLetters *deadLetterQueue, log logger.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/admin/dead-letters/"), "/")
+		if r.Method != http.MethodPost {
+			writeStatus(w, http.StatusMethodNotAllowed, map[string]interface{}{"code": http.StatusMethodNotAllowed, "message": "method not allowed"})
+			return
+		}
+
+		var err error
+		switch action {
+		case "replay":
+			err = deadLetters.replay(r.Context(), id)
+		case "discard":
+			err = deadLetters.discard(r.Context(), id)
+		default:
+			writeStatus(w, http.StatusNotFound, map[string]interface{}{"code": http.StatusNotFound, "message": "not found"})
+			return
+		}
+		switch {
+		case errors.Is(err, errDeadLetterNotFound):
+			writeStatus(w, http.StatusNotFound, map[string]interface{}{"code": http.StatusNotFound, "message": err.Error()})
+		case err != nil:
+			log.Errorw("Failed to resolve dead letter", "error", err, "id", id, "action", action)
+			writeStatus(w, http.StatusBadGateway, map[string]interface{}{"code": http.StatusBadGateway, "message": "failed to publish"})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+func writeStatus(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
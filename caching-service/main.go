@@ -2,38 +2,71 @@ package main
 
 import (
 	"io"
-	"sync"
+	"net/http"
 
 	"github.com/gin-gonic/gin"
-)
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 
-type salesByMonth = map[string]string
-type salesByPublisher = map[string]salesByMonth
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+	"github.com/Kwintenvdb/unity-publisher-management/common/health"
+	"github.com/Kwintenvdb/unity-publisher-management/common/jwtauth"
+	"github.com/Kwintenvdb/unity-publisher-management/common/lifecycle"
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+	"github.com/Kwintenvdb/unity-publisher-management/common/requestid"
+	"github.com/Kwintenvdb/unity-publisher-management/common/tracing"
+)
 
 func main() {
-	r := gin.Default()
+	cfg, err := config.Load(config.CachingService)
+	if err != nil {
+		panic(err)
+	}
+
+	log := logger.NewLogger()
+	defer log.Sync()
+
+	shutdownTracing, err := tracing.Setup(config.CachingService, cfg.Tracing)
+	if err != nil {
+		panic(err)
+	}
+
+	r := gin.New()
+	r.Use(gin.Recovery(), otelgin.Middleware(string(config.CachingService)), requestid.Middleware(), logger.AccessLog(log))
+
+	store := newMemoryStore()
+	registerMetrics(store)
 
-	// TODO make cache persistent
-	mutex := &sync.RWMutex{}
-	salesCache := make(salesByPublisher)
+	// Users may read their own sales. The scheduler writes on its runs and the gateway when it fills a cache miss.
+	// The gateway signs its own tokens with the keys it signs user tokens with, but for this service's audience.
+	keys := jwtauth.NewRemoteKeys(cfg.Auth.JWKSURL, &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)})
+	users := jwtauth.NewVerifier(keys, cfg.Auth.Issuer, cfg.Auth.Audience)
+	gateway := jwtauth.NewVerifier(keys, cfg.Auth.Issuer, string(config.CachingService))
+	scheduler, err := jwtauth.ServiceVerifier(config.Scheduler, cfg.Scheduler.PublicKey, config.CachingService)
+	if err != nil {
+		panic(err)
+	}
+	writers := jwtauth.Middleware(scheduler, gateway)
 
-	r.GET("/sales/:publisher/:month", func(c *gin.Context) {
+	r.GET("/healthz", gin.WrapF(health.Healthz))
+	r.GET("/readyz", gin.WrapF(health.Readyz(health.Check{Name: "store", Check: store.Ping})))
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	r.GET("/sales/:publisher/:month", jwtauth.Middleware(users), jwtauth.RequirePublisher("publisher"), func(c *gin.Context) {
 		publisher := c.Param("publisher")
 		month := c.Param("month")
 
-		mutex.RLock()
-		defer mutex.RUnlock()
-		if salesOfPublisher, ok := salesCache[publisher]; ok {
-			if sales, ok := salesOfPublisher[month]; ok {
-				c.String(200, sales)
-				return
-			}
+		if sales, ok := store.Get(publisher, month); ok {
+			c.String(200, sales)
+			return
 		}
 
 		c.String(404, "Sales not found")
 	})
 
-	r.POST("/sales/:publisher/:month", func(c *gin.Context) {
+	// The subject of the writer's token, its service name, is recorded as the provenance of the sales.
+	r.POST("/sales/:publisher/:month", writers, func(c *gin.Context) {
 		publisher := c.Param("publisher")
 		month := c.Param("month")
 
@@ -46,19 +79,23 @@ func main() {
 		}
 
 		sales := string(data)
+		claims, _ := jwtauth.ClaimsFromContext(c.Request.Context())
 
-		mutex.Lock()
-		if salesOfPublisher, ok := salesCache[publisher]; ok {
-			salesOfPublisher[month] = sales
-		} else {
-			salesCache[publisher] = salesByMonth{
-				month: sales,
-			}
+		if err := store.Put(publisher, month, sales, claims.Subject); err != nil {
+			logger.ForContext(log, c.Request.Context()).Errorw("Failed to cache sales", "error", err, "publisher", publisher, "month", month)
+			c.String(500, "Failed to cache sales")
+			return
 		}
-		mutex.Unlock()
 
 		c.String(200, "Sales cached")
 	})
 
-	r.Run(":8082")
+	r.GET("/provenance/:publisher", writers, func(c *gin.Context) {
+		c.JSON(200, store.Provenance(c.Param("publisher")))
+	})
+
+	lifecycle.Serve(&http.Server{
+		Addr:    cfg.CachingService.Addr,
+		Handler: r,
+	}, cfg.ShutdownTimeout, log, shutdownTracing)
 }
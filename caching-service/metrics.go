@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// registerMetrics exposes the size of the store. The gauges are computed on every scrape.
+func registerMetrics(store salesStore) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "upm",
+		Subsystem: "cache",
+		Name:      "publishers",
+		Help:      "Number of publishers with cached sales.",
+	}, func() float64 {
+		publishers, _ := store.Stats()
+		return float64(publishers)
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "upm",
+		Subsystem: "cache",
+		Name:      "months",
+		Help:      "Number of cached months across all publishers.",
+	}, func() float64 {
+		_, months := store.Stats()
+		return float64(months)
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/cache"
+)
+
+type cacheEntry struct {
+	sales      string
+	provenance cache.Provenance
+}
+
+type salesByMonth = map[string]cacheEntry
+type salesByPublisher = map[string]salesByMonth
+
+// salesStore is the storage backend of the cache.
+type salesStore interface {
+	Get(publisher, month string) (string, bool)
+	// Put stores the sales of the month, recording the service they came from.
+	Put(publisher, month, sales, source string) error
+	// Provenance returns where the cached months of the publisher came from, by month.
+	Provenance(publisher string) map[string]cache.Provenance
+	// Stats returns the number of stored publishers and publisher months.
+	Stats() (publishers int, months int)
+	// Ping reports whether the backend is able to serve requests.
+	Ping(ctx context.Context) error
+}
+
+// TODO make cache persistent
+type memoryStore struct {
+	mutex sync.RWMutex
+	sales salesByPublisher
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		sales: make(salesByPublisher),
+	}
+}
+
+func (s *memoryStore) Get(publisher, month string) (string, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if salesOfPublisher, ok := s.sales[publisher]; ok {
+		if entry, ok := salesOfPublisher[month]; ok {
+			return entry.sales, true
+		}
+	}
+	return "", false
+}
+
+func (s *memoryStore) Put(publisher, month, sales, source string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry := cacheEntry{
+		sales:      sales,
+		provenance: cache.Provenance{Source: source, Updated: time.Now().UTC()},
+	}
+	if salesOfPublisher, ok := s.sales[publisher]; ok {
+		salesOfPublisher[month] = entry
+	} else {
+		s.sales[publisher] = salesByMonth{
+			month: entry,
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Provenance(publisher string) map[string]cache.Provenance {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	provenance := make(map[string]cache.Provenance, len(s.sales[publisher]))
+	for month, entry := range s.sales[publisher] {
+		provenance[month] = entry.provenance
+	}
+	return provenance
+}
+
+func (s *memoryStore) Stats() (int, int) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	months := 0
+	for _, salesOfPublisher := range s.sales {
+		months += len(salesOfPublisher)
+	}
+	return len(s.sales), months
+}
+
+func (s *memoryStore) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
@@ -0,0 +1,40 @@
+// Package browser applies the policy of config.BrowserConfig: the attributes of the cookies the services
+// set, and which origins may call them from a browser.
+package browser
+
+import (
+	"net/http"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+)
+
+// SetCookie sets an HttpOnly cookie on the path "/" with the configured attributes. A maxAge of 0 makes
+// it a session cookie and a negative maxAge deletes it.
+func SetCookie(w http.ResponseWriter, cfg config.BrowserConfig, name, value string, maxAge int) {
+	http.SetCookie(w, Cookie(cfg, name, value, maxAge))
+}
+
+// Cookie returns the cookie SetCookie sets, for responses that are not written through a ResponseWriter.
+func Cookie(cfg config.BrowserConfig, name, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   cfg.CookieDomain,
+		MaxAge:   maxAge,
+		Secure:   cfg.CookieSecure,
+		HttpOnly: true,
+		SameSite: sameSite(cfg.CookieSameSite),
+	}
+}
+
+func sameSite(mode string) http.SameSite {
+	switch mode {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
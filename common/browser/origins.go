@@ -0,0 +1,111 @@
+package browser
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+)
+
+// Headers browsers may send on cross-origin api requests.
+var allowedHeaders = strings.Join([]string{"Authorization", "Content-Type", "X-Request-Id"}, ", ")
+
+// Response headers cross-origin pages may read, such as the number of months a forecast was made without.
+var exposedHeaders = strings.Join([]string{"X-Request-Id", "X-Partial-History"}, ", ")
+
+const preflightMaxAge = 600
+
+// origins decides whether requests of an origin come from the service's own site or an allowed origin.
+type origins map[string]bool
+
+func newOrigins(cfg config.BrowserConfig) origins {
+	allowed := make(origins, len(cfg.AllowedOrigins))
+	for _, origin := range cfg.AllowedOrigins {
+		allowed[normalize(origin)] = true
+	}
+	return allowed
+}
+
+func normalize(origin string) string {
+	return strings.TrimSuffix(strings.ToLower(origin), "/")
+}
+
+// allows reports whether the origin is that of the request's host or one of the allowed origins.
+// The scheme of the own origin is not compared, as TLS may end at a proxy in front of the service.
+func (o origins) allows(origin string, req *http.Request) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return strings.EqualFold(u.Host, req.Host) || o[normalize(origin)]
+}
+
+// CORS lets the allowed origins call the service with the user's cookies. Preflight requests of other
+// origins are refused; their other requests get no CORS headers, so browsers hide the responses.
+func CORS(cfg config.BrowserConfig) gin.HandlerFunc {
+	allowed := newOrigins(cfg)
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		c.Writer.Header().Add("Vary", "Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if !allowed[normalize(origin)] {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		header := c.Writer.Header()
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Set("Access-Control-Allow-Credentials", "true")
+		if preflight {
+			header.Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+			header.Set("Access-Control-Allow-Headers", allowedHeaders)
+			header.Set("Access-Control-Max-Age", strconv.Itoa(preflightMaxAge))
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		header.Set("Access-Control-Expose-Headers", exposedHeaders)
+		c.Next()
+	}
+}
+
+// CheckOrigin refuses state-changing requests that a page of another site made the browser send, which
+// would carry the user's cookies. Browsers name the page's origin in the Origin header, or at least in
+// the Referer header. Requests with neither come from other programs and are let through, as they
+// cannot ride on a browser's cookies.
+func CheckOrigin(cfg config.BrowserConfig) gin.HandlerFunc {
+	allowed := newOrigins(cfg)
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			if referer, err := url.Parse(c.GetHeader("Referer")); err == nil && referer.Host != "" {
+				origin = referer.Scheme + "://" + referer.Host
+			}
+		}
+		if origin == "" || allowed.allows(origin, c.Request) {
+			c.Next()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"code":    http.StatusForbidden,
+			"message": "cross-site request refused",
+		})
+	}
+}
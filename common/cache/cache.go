@@ -0,0 +1,11 @@
+// Package cache describes where the sales stored by the caching service came from.
+package cache
+
+import "time"
+
+// Provenance of a cached month of sales.
+type Provenance struct {
+	// Service that wrote the sales: the scheduler on its runs, or the gateway when it fills a cache miss.
+	Source  string    `json:"source"`
+	Updated time.Time `json:"updated"`
+}
@@ -0,0 +1,680 @@
+// Package config loads the configuration shared by all services.
+//
+// Values are resolved in order of precedence: environment variables, the YAML file named by
+// UPM_CONFIG, and the defaults below. See upm.example.yaml in the repository root for every key.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Service identifies the binary loading the configuration, which determines what is validated.
+type Service string
+
+const (
+	Gateway        Service = "api-gateway"
+	ApiService     Service = "api-service"
+	CachingService Service = "caching-service"
+	Scheduler      Service = "caching-scheduler"
+)
+
+type Config struct {
+	Gateway        GatewayConfig        `yaml:"gateway"`
+	ApiService     ApiServiceConfig     `yaml:"apiService"`
+	CachingService CachingServiceConfig `yaml:"cachingService"`
+	Scheduler      SchedulerConfig      `yaml:"scheduler"`
+	Kafka          KafkaConfig          `yaml:"kafka"`
+	Auth           AuthConfig           `yaml:"auth"`
+	Tracing        TracingConfig        `yaml:"tracing"`
+	Redis          RedisConfig          `yaml:"redis"`
+	Browser        BrowserConfig        `yaml:"browser"`
+
+	// How long services wait for in-flight work on shutdown. Default 15s. Env UPM_SHUTDOWN_TIMEOUT.
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+}
+
+type GatewayConfig struct {
+	// Listen address. Default ":8080". Env UPM_GATEWAY_ADDR.
+	Addr string `yaml:"addr"`
+	// Private keys that sign JWTs. At least one is required.
+	// Env UPM_JWT_KEY_FILE configures a single key named after its file.
+	SigningKeys []SigningKeyConfig `yaml:"signingKeys"`
+	// How long access tokens are valid. Default 15m. Env UPM_ACCESS_TOKEN_LIFETIME.
+	AccessTokenLifetime time.Duration `yaml:"accessTokenLifetime"`
+	// How long a login lasts before the user has to log in again. Default 72h. Env UPM_REFRESH_TOKEN_LIFETIME.
+	RefreshTokenLifetime time.Duration `yaml:"refreshTokenLifetime"`
+	// How long a retired key stays published after the last token it signed expired,
+	// and how long before its activation a new key is published. Default 1h. Env UPM_JWT_KEY_OVERLAP.
+	KeyOverlap time.Duration `yaml:"keyOverlap"`
+	// Where revoked tokens are kept: "memory" or "redis". Memory is neither shared between replicas
+	// nor kept across restarts, so it only suits a single replica. Default "memory". Env UPM_REVOCATION_STORE.
+	RevocationStore string `yaml:"revocationStore"`
+	// Directory of the frontend build to serve. Default "", which serves the build embedded in the binary,
+	// if any. Env UPM_STATIC_DIR.
+	StaticDir string `yaml:"staticDir"`
+	// Content-Security-Policy header of the frontend. Env UPM_CONTENT_SECURITY_POLICY.
+	ContentSecurityPolicy string `yaml:"contentSecurityPolicy"`
+	// Addresses or CIDRs of the proxies whose X-Forwarded-For header tells the client address.
+	// Default none, so the address of the connection is used. Env UPM_TRUSTED_PROXIES, comma separated.
+	TrustedProxies []string        `yaml:"trustedProxies"`
+	RateLimit      RateLimitConfig `yaml:"rateLimit"`
+	Upstream       UpstreamConfig  `yaml:"upstream"`
+}
+
+// UpstreamConfig tunes how the gateway proxies requests to the api-service.
+type UpstreamConfig struct {
+	// How long a login may take, including the api-service logging in to Unity. Default 30s.
+	// Env UPM_UPSTREAM_LOGIN_TIMEOUT.
+	LoginTimeout time.Duration `yaml:"loginTimeout"`
+	// How long an api request may take, including sending the response. Default 60s. Env UPM_UPSTREAM_API_TIMEOUT.
+	ApiTimeout time.Duration `yaml:"apiTimeout"`
+	// Attempts of a GET request that failed to connect or got 502, 503 or 504, 1 disabling retries.
+	// Default 3. Env UPM_UPSTREAM_ATTEMPTS.
+	Attempts int `yaml:"attempts"`
+	// Wait before the first retry, doubling with every further retry. Default 100ms. Env UPM_UPSTREAM_RETRY_BACKOFF.
+	RetryBackoff time.Duration `yaml:"retryBackoff"`
+}
+
+// RateLimitConfig limits logins per client address and per email, and api requests per user.
+type RateLimitConfig struct {
+	// Where the counters are kept: "memory" or "redis". Memory limits each replica separately. Default "memory".
+	// Env UPM_RATE_LIMIT_STORE.
+	Store string `yaml:"store"`
+	// Login attempts allowed per client address and per email within LoginWindow. Default 20 and 10.
+	// Env UPM_RATE_LIMIT_LOGIN_PER_IP and UPM_RATE_LIMIT_LOGIN_PER_EMAIL.
+	LoginPerIP    int `yaml:"loginPerIp"`
+	LoginPerEmail int `yaml:"loginPerEmail"`
+	// Default 15m. Env UPM_RATE_LIMIT_LOGIN_WINDOW.
+	LoginWindow time.Duration `yaml:"loginWindow"`
+	// How long logins of an address or email are refused after a failed attempt, doubling with every
+	// further failure. Default 1s. Env UPM_RATE_LIMIT_FAILURE_BACKOFF.
+	FailureBackoff time.Duration `yaml:"failureBackoff"`
+	// Failures in a row after which the address or email is locked out for LockoutDuration. Default 10.
+	// Env UPM_RATE_LIMIT_LOCKOUT_AFTER.
+	LockoutAfter int `yaml:"lockoutAfter"`
+	// Default 15m. Env UPM_RATE_LIMIT_LOCKOUT_DURATION.
+	LockoutDuration time.Duration `yaml:"lockoutDuration"`
+	// Api requests allowed per user within ApiWindow. Default 300. Env UPM_RATE_LIMIT_API_REQUESTS.
+	ApiRequests int `yaml:"apiRequests"`
+	// Default 1m. Env UPM_RATE_LIMIT_API_WINDOW.
+	ApiWindow time.Duration `yaml:"apiWindow"`
+}
+
+// SigningKeyConfig is a PEM encoded RSA or Ed25519 private key. RSA keys sign with RS256, Ed25519 keys with EdDSA.
+type SigningKeyConfig struct {
+	// Key id put in the kid header of the tokens the key signs.
+	ID string `yaml:"id"`
+	// PEM file holding the PKCS #8 or PKCS #1 private key.
+	File string `yaml:"file"`
+	// When the key starts signing tokens. The key with the latest activation in the past signs.
+	ActiveFrom time.Time `yaml:"activeFrom"`
+}
+
+type ApiServiceConfig struct {
+	// Listen address. Default ":8081". Env UPM_API_SERVICE_ADDR.
+	Addr string `yaml:"addr"`
+	// Host other services reach the api-service at. Default "localhost:8081". Env UPM_API_SERVICE.
+	Host string `yaml:"host"`
+	// Where the package alias table is kept: "file" or "redis". The file is not shared between replicas,
+	// which would resolve package names to different ids, so it only suits a single one.
+	// Default "file". Env UPM_PACKAGE_ALIAS_STORE.
+	PackageAliasStore string `yaml:"packageAliasStore"`
+	// File the package alias table is persisted to with the file store. Default "package-aliases.json".
+	// Env UPM_PACKAGE_ALIASES.
+	PackageAliases string `yaml:"packageAliases"`
+	// Where the anomalies already published are remembered: "memory" or "redis". Memory is neither shared
+	// between replicas nor kept across restarts, so each replica publishes an anomaly again after it starts.
+	// Default "memory". Env UPM_SENT_ANOMALY_STORE.
+	SentAnomalyStore string `yaml:"sentAnomalyStore"`
+	// Whether readiness requires the Unity login page to be reachable. Default false. Env UPM_READY_CHECK_UNITY.
+	ReadyCheckUnity bool `yaml:"readyCheckUnity"`
+}
+
+type CachingServiceConfig struct {
+	// Listen address. Default ":8082". Env UPM_CACHING_SERVICE_ADDR.
+	Addr string `yaml:"addr"`
+	// Host other services reach the caching service at. Default "localhost:8082". Env UPM_CACHING_SERVICE.
+	Host string `yaml:"host"`
+}
+
+type SchedulerConfig struct {
+	// Listen address of the probes and metrics. Default ":8083". Env UPM_SCHEDULER_ADDR.
+	Addr string `yaml:"addr"`
+	// Time between caching runs. Default 5m. Env UPM_SCHEDULER_INTERVAL.
+	Interval time.Duration `yaml:"interval"`
+	// Kafka consumer group. Default "caching-scheduler". Env UPM_SCHEDULER_GROUP_ID.
+	GroupID string `yaml:"groupId"`
+	// Topic of the messages the scheduler could not handle. Default "caching-scheduler.dead-letters".
+	// Env UPM_SCHEDULER_DEAD_LETTER_TOPIC.
+	DeadLetterTopic string `yaml:"deadLetterTopic"`
+	// Bearer token of the admin endpoints, which inspect and replay dead letters. They are disabled without one.
+	// Env UPM_SCHEDULER_ADMIN_TOKEN.
+	AdminToken string `yaml:"adminToken"`
+	// PEM private key the scheduler signs its service tokens with. Required. Env UPM_SCHEDULER_IDENTITY_KEY.
+	IdentityKey string `yaml:"identityKey"`
+	// PEM public key of IdentityKey. The api-service and caching-service require it. Env UPM_SCHEDULER_PUBLIC_KEY.
+	PublicKey string `yaml:"publicKey"`
+}
+
+type KafkaConfig struct {
+	// How services exchange messages: "kafka", or "memory" to pass them only between the publishers and
+	// subscribers of the same process, which suits development without Kafka. Default "kafka". Env UPM_KAFKA_DRIVER.
+	Driver string `yaml:"driver"`
+	// Broker addresses. Required by the kafka driver. Default ["localhost:61162"]. Env UPM_KAFKA_BROKERS, comma separated.
+	Brokers []string `yaml:"brokers"`
+	// Events the gateway holds while Kafka does not accept them. Once full, further events are dropped.
+	// Default 1000. Env UPM_KAFKA_PRODUCER_QUEUE.
+	ProducerQueue int `yaml:"producerQueue"`
+	// Keys that encrypt the Unity sessions in user.authentications messages. The gateway encrypts with the
+	// key with the latest activation in the past; the scheduler decrypts with any of them. Required by both.
+	// Env UPM_MESSAGE_KEY_FILE configures a single key named after its file.
+	EncryptionKeys []EncryptionKeyConfig `yaml:"encryptionKeys"`
+}
+
+// EncryptionKeyConfig is a 256-bit AES key, base64 encoded in a file.
+type EncryptionKeyConfig struct {
+	// Key id stored with the messages the key encrypts.
+	ID string `yaml:"id"`
+	// File holding the base64 encoded key.
+	File string `yaml:"file"`
+	// When the key starts encrypting messages.
+	ActiveFrom time.Time `yaml:"activeFrom"`
+}
+
+// AuthConfig describes the tokens the gateway issues to users and the other services verify.
+type AuthConfig struct {
+	// Issuer claim of user tokens. Default "upm-gateway". Env UPM_JWT_ISSUER.
+	Issuer string `yaml:"issuer"`
+	// Audience claim of user tokens. Default "upm". Env UPM_JWT_AUDIENCE.
+	Audience string `yaml:"audience"`
+	// Where services fetch the gateway's verification keys. Default "http://localhost:8080/.well-known/jwks.json". Env UPM_JWKS_URL.
+	JWKSURL string `yaml:"jwksUrl"`
+}
+
+type TracingConfig struct {
+	// Where spans are exported: "none", "stdout", "file" or "otlp". Default "none". Env UPM_TRACING_EXPORTER.
+	Exporter string `yaml:"exporter"`
+	// File the "file" exporter appends spans to. Default "traces.json". Env UPM_TRACING_FILE.
+	File string `yaml:"file"`
+	// OTLP/HTTP collector address for the "otlp" exporter. Default "localhost:4318". Env UPM_TRACING_ENDPOINT.
+	Endpoint string `yaml:"endpoint"`
+	// Whether to connect to the collector without TLS. Default true. Env UPM_TRACING_INSECURE.
+	Insecure bool `yaml:"insecure"`
+	// Fraction of new traces that are sampled. Default 1. Env UPM_TRACING_SAMPLE_RATIO.
+	SampleRatio float64 `yaml:"sampleRatio"`
+}
+
+// BrowserConfig describes how the services let browsers in: the cookies they set and the origins they accept.
+type BrowserConfig struct {
+	// Origins besides the gateway's own that may call the api with the user's cookies, such as a dashboard
+	// served elsewhere, e.g. "https://dashboard.example.com". Default none. Env UPM_ALLOWED_ORIGINS, comma separated.
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+	// Whether cookies are only sent over HTTPS. Browsers treat http://localhost as secure. Default true.
+	// Env UPM_COOKIE_SECURE.
+	CookieSecure bool `yaml:"cookieSecure"`
+	// SameSite attribute of cookies: "lax", "strict" or "none". An allowed origin on another site needs "none".
+	// Default "lax". Env UPM_COOKIE_SAMESITE.
+	CookieSameSite string `yaml:"cookieSameSite"`
+	// Domain attribute of cookies. Default "", which limits them to the host that set them. Env UPM_COOKIE_DOMAIN.
+	CookieDomain string `yaml:"cookieDomain"`
+}
+
+type RedisConfig struct {
+	// Server address. Default "localhost:6379". Env UPM_REDIS_ADDR.
+	Addr string `yaml:"addr"`
+	// Password, if the server requires one. Env UPM_REDIS_PASSWORD.
+	Password string `yaml:"password"`
+	// Database number. Default 0.
+	DB int `yaml:"db"`
+}
+
+// defaultContentSecurityPolicy only allows the frontend to load resources from and connect to its own origin.
+const defaultContentSecurityPolicy = "default-src 'self'; script-src 'self'; style-src 'self' 'unsafe-inline'; " +
+	"img-src 'self' data:; font-src 'self' data:; connect-src 'self'; object-src 'none'; " +
+	"base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
+func defaults() *Config {
+	return &Config{
+		Gateway: GatewayConfig{
+			Addr:                  ":8080",
+			AccessTokenLifetime:   15 * time.Minute,
+			RefreshTokenLifetime:  72 * time.Hour,
+			KeyOverlap:            time.Hour,
+			RevocationStore:       "memory",
+			ContentSecurityPolicy: defaultContentSecurityPolicy,
+			RateLimit: RateLimitConfig{
+				Store:           "memory",
+				LoginPerIP:      20,
+				LoginPerEmail:   10,
+				LoginWindow:     15 * time.Minute,
+				FailureBackoff:  time.Second,
+				LockoutAfter:    10,
+				LockoutDuration: 15 * time.Minute,
+				ApiRequests:     300,
+				ApiWindow:       time.Minute,
+			},
+			Upstream: UpstreamConfig{
+				LoginTimeout: 30 * time.Second,
+				ApiTimeout:   time.Minute,
+				Attempts:     3,
+				RetryBackoff: 100 * time.Millisecond,
+			},
+		},
+		ApiService: ApiServiceConfig{
+			Addr:              ":8081",
+			Host:              "localhost:8081",
+			PackageAliasStore: "file",
+			PackageAliases:    "package-aliases.json",
+			SentAnomalyStore:  "memory",
+		},
+		CachingService: CachingServiceConfig{
+			Addr: ":8082",
+			Host: "localhost:8082",
+		},
+		Scheduler: SchedulerConfig{
+			Addr:            ":8083",
+			Interval:        5 * time.Minute,
+			GroupID:         "caching-scheduler",
+			DeadLetterTopic: "caching-scheduler.dead-letters",
+		},
+		Kafka: KafkaConfig{
+			Driver:        "kafka",
+			Brokers:       []string{"localhost:61162"},
+			ProducerQueue: 1000,
+		},
+		Auth: AuthConfig{
+			Issuer:   "upm-gateway",
+			Audience: "upm",
+			JWKSURL:  "http://localhost:8080/.well-known/jwks.json",
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			File:        "traces.json",
+			Endpoint:    "localhost:4318",
+			Insecure:    true,
+			SampleRatio: 1,
+		},
+		Redis: RedisConfig{
+			Addr: "localhost:6379",
+		},
+		Browser: BrowserConfig{
+			CookieSecure:   true,
+			CookieSameSite: "lax",
+		},
+		ShutdownTimeout: 15 * time.Second,
+	}
+}
+
+// Load reads the configuration and validates the settings the given service needs.
+func Load(service Service) (*Config, error) {
+	cfg := defaults()
+
+	if path, found := os.LookupEnv("UPM_CONFIG"); found {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(service); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) applyEnv() error {
+	stringFields := map[string]*string{
+		"UPM_GATEWAY_ADDR":                &cfg.Gateway.Addr,
+		"UPM_REVOCATION_STORE":            &cfg.Gateway.RevocationStore,
+		"UPM_STATIC_DIR":                  &cfg.Gateway.StaticDir,
+		"UPM_RATE_LIMIT_STORE":            &cfg.Gateway.RateLimit.Store,
+		"UPM_CONTENT_SECURITY_POLICY":     &cfg.Gateway.ContentSecurityPolicy,
+		"UPM_REDIS_ADDR":                  &cfg.Redis.Addr,
+		"UPM_REDIS_PASSWORD":              &cfg.Redis.Password,
+		"UPM_COOKIE_SAMESITE":             &cfg.Browser.CookieSameSite,
+		"UPM_COOKIE_DOMAIN":               &cfg.Browser.CookieDomain,
+		"UPM_API_SERVICE_ADDR":            &cfg.ApiService.Addr,
+		"UPM_API_SERVICE":                 &cfg.ApiService.Host,
+		"UPM_PACKAGE_ALIAS_STORE":         &cfg.ApiService.PackageAliasStore,
+		"UPM_PACKAGE_ALIASES":             &cfg.ApiService.PackageAliases,
+		"UPM_SENT_ANOMALY_STORE":          &cfg.ApiService.SentAnomalyStore,
+		"UPM_CACHING_SERVICE_ADDR":        &cfg.CachingService.Addr,
+		"UPM_CACHING_SERVICE":             &cfg.CachingService.Host,
+		"UPM_SCHEDULER_ADDR":              &cfg.Scheduler.Addr,
+		"UPM_SCHEDULER_GROUP_ID":          &cfg.Scheduler.GroupID,
+		"UPM_SCHEDULER_DEAD_LETTER_TOPIC": &cfg.Scheduler.DeadLetterTopic,
+		"UPM_SCHEDULER_ADMIN_TOKEN":       &cfg.Scheduler.AdminToken,
+		"UPM_SCHEDULER_IDENTITY_KEY":      &cfg.Scheduler.IdentityKey,
+		"UPM_SCHEDULER_PUBLIC_KEY":        &cfg.Scheduler.PublicKey,
+		"UPM_KAFKA_DRIVER":                &cfg.Kafka.Driver,
+		"UPM_JWT_ISSUER":                  &cfg.Auth.Issuer,
+		"UPM_JWT_AUDIENCE":                &cfg.Auth.Audience,
+		"UPM_JWKS_URL":                    &cfg.Auth.JWKSURL,
+		"UPM_TRACING_EXPORTER":            &cfg.Tracing.Exporter,
+		"UPM_TRACING_FILE":                &cfg.Tracing.File,
+		"UPM_TRACING_ENDPOINT":            &cfg.Tracing.Endpoint,
+	}
+	for name, field := range stringFields {
+		if value, found := os.LookupEnv(name); found {
+			*field = value
+		}
+	}
+
+	durations := map[string]*time.Duration{
+		"UPM_SHUTDOWN_TIMEOUT":            &cfg.ShutdownTimeout,
+		"UPM_SCHEDULER_INTERVAL":          &cfg.Scheduler.Interval,
+		"UPM_JWT_KEY_OVERLAP":             &cfg.Gateway.KeyOverlap,
+		"UPM_ACCESS_TOKEN_LIFETIME":       &cfg.Gateway.AccessTokenLifetime,
+		"UPM_REFRESH_TOKEN_LIFETIME":      &cfg.Gateway.RefreshTokenLifetime,
+		"UPM_RATE_LIMIT_LOGIN_WINDOW":     &cfg.Gateway.RateLimit.LoginWindow,
+		"UPM_RATE_LIMIT_FAILURE_BACKOFF":  &cfg.Gateway.RateLimit.FailureBackoff,
+		"UPM_RATE_LIMIT_LOCKOUT_DURATION": &cfg.Gateway.RateLimit.LockoutDuration,
+		"UPM_RATE_LIMIT_API_WINDOW":       &cfg.Gateway.RateLimit.ApiWindow,
+		"UPM_UPSTREAM_LOGIN_TIMEOUT":      &cfg.Gateway.Upstream.LoginTimeout,
+		"UPM_UPSTREAM_API_TIMEOUT":        &cfg.Gateway.Upstream.ApiTimeout,
+		"UPM_UPSTREAM_RETRY_BACKOFF":      &cfg.Gateway.Upstream.RetryBackoff,
+	}
+	for name, field := range durations {
+		if value, found := os.LookupEnv(name); found {
+			duration, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("invalid duration in %s: %w", name, err)
+			}
+			*field = duration
+		}
+	}
+
+	ints := map[string]*int{
+		"UPM_RATE_LIMIT_LOGIN_PER_IP":    &cfg.Gateway.RateLimit.LoginPerIP,
+		"UPM_RATE_LIMIT_LOGIN_PER_EMAIL": &cfg.Gateway.RateLimit.LoginPerEmail,
+		"UPM_RATE_LIMIT_LOCKOUT_AFTER":   &cfg.Gateway.RateLimit.LockoutAfter,
+		"UPM_RATE_LIMIT_API_REQUESTS":    &cfg.Gateway.RateLimit.ApiRequests,
+		"UPM_UPSTREAM_ATTEMPTS":          &cfg.Gateway.Upstream.Attempts,
+		"UPM_KAFKA_PRODUCER_QUEUE":       &cfg.Kafka.ProducerQueue,
+	}
+	for name, field := range ints {
+		if value, found := os.LookupEnv(name); found {
+			number, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid number in %s: %w", name, err)
+			}
+			*field = number
+		}
+	}
+
+	bools := map[string]*bool{
+		"UPM_READY_CHECK_UNITY": &cfg.ApiService.ReadyCheckUnity,
+		"UPM_TRACING_INSECURE":  &cfg.Tracing.Insecure,
+		"UPM_COOKIE_SECURE":     &cfg.Browser.CookieSecure,
+	}
+	for name, field := range bools {
+		if value, found := os.LookupEnv(name); found {
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid boolean in %s: %w", name, err)
+			}
+			*field = enabled
+		}
+	}
+
+	if value, found := os.LookupEnv("UPM_TRACING_SAMPLE_RATIO"); found {
+		ratio, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number in UPM_TRACING_SAMPLE_RATIO: %w", err)
+		}
+		cfg.Tracing.SampleRatio = ratio
+	}
+
+	if value, found := os.LookupEnv("UPM_JWT_KEY_FILE"); found {
+		cfg.Gateway.SigningKeys = []SigningKeyConfig{{
+			ID:   strings.TrimSuffix(filepath.Base(value), filepath.Ext(value)),
+			File: value,
+		}}
+	}
+
+	if value, found := os.LookupEnv("UPM_MESSAGE_KEY_FILE"); found {
+		cfg.Kafka.EncryptionKeys = []EncryptionKeyConfig{{
+			ID:   strings.TrimSuffix(filepath.Base(value), filepath.Ext(value)),
+			File: value,
+		}}
+	}
+
+	if value, found := os.LookupEnv("UPM_ALLOWED_ORIGINS"); found {
+		cfg.Browser.AllowedOrigins = splitList(value)
+	}
+
+	if value, found := os.LookupEnv("UPM_TRUSTED_PROXIES"); found {
+		cfg.Gateway.TrustedProxies = splitList(value)
+	}
+
+	if value, found := os.LookupEnv("UPM_KAFKA_BROKERS"); found {
+		cfg.Kafka.Brokers = splitList(value)
+	}
+	return nil
+}
+
+func (cfg *Config) validate(service Service) error {
+	var errs []error
+	require := func(value, name string) {
+		if value == "" {
+			errs = append(errs, fmt.Errorf("%s must be set", name))
+		}
+	}
+
+	if cfg.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdownTimeout must be positive"))
+	}
+	cfg.validateTracing(&errs)
+
+	switch service {
+	case Gateway:
+		require(cfg.Gateway.Addr, "gateway.addr")
+		cfg.validateSigningKeys(&errs)
+		cfg.validateStore("gateway.revocationStore", cfg.Gateway.RevocationStore, &errs)
+		cfg.validateRateLimit(&errs)
+		cfg.validateUpstream(&errs)
+		cfg.validateBrowser(&errs)
+		require(cfg.Auth.Issuer, "auth.issuer")
+		require(cfg.Auth.Audience, "auth.audience")
+		require(cfg.ApiService.Host, "apiService.host")
+		require(cfg.CachingService.Host, "cachingService.host")
+		cfg.validateKafka(&errs)
+		cfg.validateEncryptionKeys(&errs)
+	case ApiService:
+		require(cfg.ApiService.Addr, "apiService.addr")
+		cfg.validateBrowser(&errs)
+		switch cfg.ApiService.PackageAliasStore {
+		case "file":
+			require(cfg.ApiService.PackageAliases, "apiService.packageAliases")
+		case "redis":
+			require(cfg.Redis.Addr, "redis.addr")
+		default:
+			errs = append(errs, fmt.Errorf("unknown apiService.packageAliasStore %q", cfg.ApiService.PackageAliasStore))
+		}
+		cfg.validateStore("apiService.sentAnomalyStore", cfg.ApiService.SentAnomalyStore, &errs)
+		require(cfg.Scheduler.PublicKey, "scheduler.publicKey")
+		require(cfg.CachingService.Host, "cachingService.host")
+		require(cfg.Auth.Issuer, "auth.issuer")
+		require(cfg.Auth.Audience, "auth.audience")
+		require(cfg.Auth.JWKSURL, "auth.jwksUrl")
+		cfg.validateKafka(&errs)
+	case CachingService:
+		require(cfg.CachingService.Addr, "cachingService.addr")
+		require(cfg.Scheduler.PublicKey, "scheduler.publicKey")
+		require(cfg.Auth.Issuer, "auth.issuer")
+		require(cfg.Auth.Audience, "auth.audience")
+		require(cfg.Auth.JWKSURL, "auth.jwksUrl")
+	case Scheduler:
+		require(cfg.Scheduler.Addr, "scheduler.addr")
+		require(cfg.Scheduler.GroupID, "scheduler.groupId")
+		require(cfg.Scheduler.DeadLetterTopic, "scheduler.deadLetterTopic")
+		require(cfg.Scheduler.IdentityKey, "scheduler.identityKey")
+		require(cfg.ApiService.Host, "apiService.host")
+		require(cfg.CachingService.Host, "cachingService.host")
+		if cfg.Scheduler.Interval <= 0 {
+			errs = append(errs, errors.New("scheduler.interval must be positive"))
+		}
+		cfg.validateKafka(&errs)
+		cfg.validateEncryptionKeys(&errs)
+	default:
+		errs = append(errs, fmt.Errorf("unknown service %q", service))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration for %s: %w", service, errors.Join(errs...))
+	}
+	return nil
+}
+
+func (cfg *Config) validateKafka(errs *[]error) {
+	switch cfg.Kafka.Driver {
+	case "memory":
+	case "kafka":
+		if len(cfg.Kafka.Brokers) == 0 {
+			*errs = append(*errs, errors.New("kafka.brokers must not be empty"))
+		}
+	default:
+		*errs = append(*errs, fmt.Errorf("unknown kafka.driver %q", cfg.Kafka.Driver))
+	}
+	if cfg.Kafka.ProducerQueue <= 0 {
+		*errs = append(*errs, errors.New("kafka.producerQueue must be positive"))
+	}
+}
+
+func (cfg *Config) validateEncryptionKeys(errs *[]error) {
+	if len(cfg.Kafka.EncryptionKeys) == 0 {
+		*errs = append(*errs, errors.New("kafka.encryptionKeys must not be empty"))
+	}
+	ids := map[string]bool{}
+	for i, key := range cfg.Kafka.EncryptionKeys {
+		if key.ID == "" {
+			*errs = append(*errs, fmt.Errorf("kafka.encryptionKeys[%d].id must be set", i))
+		} else if ids[key.ID] {
+			*errs = append(*errs, fmt.Errorf("kafka.encryptionKeys[%d].id %q is not unique", i, key.ID))
+		}
+		ids[key.ID] = true
+		if key.File == "" {
+			*errs = append(*errs, fmt.Errorf("kafka.encryptionKeys[%d].file must be set", i))
+		}
+	}
+}
+
+func (cfg *Config) validateSigningKeys(errs *[]error) {
+	if len(cfg.Gateway.SigningKeys) == 0 {
+		*errs = append(*errs, errors.New("gateway.signingKeys must not be empty"))
+	}
+	if cfg.Gateway.AccessTokenLifetime <= 0 || cfg.Gateway.RefreshTokenLifetime < cfg.Gateway.AccessTokenLifetime {
+		*errs = append(*errs, errors.New("gateway.accessTokenLifetime must be positive and at most gateway.refreshTokenLifetime"))
+	}
+	if cfg.Gateway.KeyOverlap < 0 {
+		*errs = append(*errs, errors.New("gateway.keyOverlap must not be negative"))
+	}
+	ids := map[string]bool{}
+	for i, key := range cfg.Gateway.SigningKeys {
+		if key.ID == "" {
+			*errs = append(*errs, fmt.Errorf("gateway.signingKeys[%d].id must be set", i))
+		} else if ids[key.ID] {
+			*errs = append(*errs, fmt.Errorf("gateway.signingKeys[%d].id %q is not unique", i, key.ID))
+		}
+		ids[key.ID] = true
+		if key.File == "" {
+			*errs = append(*errs, fmt.Errorf("gateway.signingKeys[%d].file must be set", i))
+		}
+	}
+}
+
+func (cfg *Config) validateBrowser(errs *[]error) {
+	switch cfg.Browser.CookieSameSite {
+	case "lax", "strict":
+	case "none":
+		if !cfg.Browser.CookieSecure {
+			*errs = append(*errs, errors.New("browser.cookieSecure must be true when browser.cookieSameSite is none"))
+		}
+	default:
+		*errs = append(*errs, fmt.Errorf("unknown browser.cookieSameSite %q", cfg.Browser.CookieSameSite))
+	}
+	for i, origin := range cfg.Browser.AllowedOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			*errs = append(*errs, fmt.Errorf("browser.allowedOrigins[%d] %q must be a scheme and host", i, origin))
+		}
+	}
+}
+
+// validateStore checks a setting choosing between the memory and redis backends.
+func (cfg *Config) validateStore(name, store string, errs *[]error) {
+	switch store {
+	case "memory":
+	case "redis":
+		if cfg.Redis.Addr == "" {
+			*errs = append(*errs, fmt.Errorf("redis.addr must be set when %s is redis", name))
+		}
+	default:
+		*errs = append(*errs, fmt.Errorf("unknown %s %q", name, store))
+	}
+}
+
+func (cfg *Config) validateRateLimit(errs *[]error) {
+	limits := cfg.Gateway.RateLimit
+	cfg.validateStore("gateway.rateLimit.store", limits.Store, errs)
+	if limits.LoginPerIP <= 0 || limits.LoginPerEmail <= 0 || limits.LockoutAfter <= 0 || limits.ApiRequests <= 0 {
+		*errs = append(*errs, errors.New("gateway.rateLimit limits must be positive"))
+	}
+	if limits.LoginWindow <= 0 || limits.FailureBackoff <= 0 || limits.LockoutDuration <= 0 || limits.ApiWindow <= 0 {
+		*errs = append(*errs, errors.New("gateway.rateLimit durations must be positive"))
+	}
+}
+
+func (cfg *Config) validateUpstream(errs *[]error) {
+	upstream := cfg.Gateway.Upstream
+	if upstream.LoginTimeout <= 0 || upstream.ApiTimeout <= 0 || upstream.RetryBackoff <= 0 {
+		*errs = append(*errs, errors.New("gateway.upstream durations must be positive"))
+	}
+	if upstream.Attempts < 1 {
+		*errs = append(*errs, errors.New("gateway.upstream.attempts must be at least 1"))
+	}
+}
+
+func (cfg *Config) validateTracing(errs *[]error) {
+	switch cfg.Tracing.Exporter {
+	case "none", "stdout":
+	case "file":
+		if cfg.Tracing.File == "" {
+			*errs = append(*errs, errors.New("tracing.file must be set for the file exporter"))
+		}
+	case "otlp":
+		if cfg.Tracing.Endpoint == "" {
+			*errs = append(*errs, errors.New("tracing.endpoint must be set for the otlp exporter"))
+		}
+	default:
+		*errs = append(*errs, fmt.Errorf("unknown tracing.exporter %q", cfg.Tracing.Exporter))
+	}
+	if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+		*errs = append(*errs, errors.New("tracing.sampleRatio must be between 0 and 1"))
+	}
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,179 @@
+// Package envelope encrypts the secrets services pass each other over Kafka. Every value is encrypted with
+// a data key of its own, which is encrypted in turn with a key encryption key named by its id, so keys can be
+// rotated while messages encrypted with the previous key are still being read.
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+)
+
+const keySize = 32
+
+var (
+	// ErrUnknownKey is returned for values encrypted with a key the key ring does not hold.
+	ErrUnknownKey = errors.New("unknown encryption key")
+	// ErrDecrypt is returned for values that were tampered with, or are not bound to the given associated data.
+	ErrDecrypt = errors.New("failed to decrypt")
+)
+
+// Sealed is an encrypted value along with its data key, encrypted with the key of KeyID.
+// Both fields start with the nonce they were encrypted with.
+type Sealed struct {
+	KeyID string `json:"kid"`
+	Key   []byte `json:"key"`
+	Data  []byte `json:"data"`
+}
+
+type encryptionKey struct {
+	id         string
+	aead       cipher.AEAD
+	activeFrom time.Time
+}
+
+// KeyRing holds the key encryption keys. The key with the latest activation in the past seals values,
+// and every key opens them.
+type KeyRing struct {
+	keys []encryptionKey // Ordered by activation.
+}
+
+// Load reads the keys of the configuration.
+func Load(cfgs []config.EncryptionKeyConfig) (*KeyRing, error) {
+	ring := &KeyRing{}
+	for _, cfg := range cfgs {
+		aead, err := loadKey(cfg.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load encryption key %s: %w", cfg.ID, err)
+		}
+		ring.keys = append(ring.keys, encryptionKey{id: cfg.ID, aead: aead, activeFrom: cfg.ActiveFrom})
+	}
+	sort.Slice(ring.keys, func(i, j int) bool {
+		return ring.keys[i].activeFrom.Before(ring.keys[j].activeFrom)
+	})
+	return ring, nil
+}
+
+func loadKey(file string) (cipher.AEAD, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("key must be %d bytes, not %d", keySize, len(key))
+	}
+	return newAEAD(key)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts v encoded as JSON. The associated data, such as the publisher the value belongs to,
+// is not encrypted but must be given again to open the value, so it cannot be moved to another message.
+func (r *KeyRing) Seal(v interface{}, associated []byte) (*Sealed, error) {
+	key, err := r.active(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	sealedData, err := seal(data, plaintext, associated)
+	if err != nil {
+		return nil, err
+	}
+	sealedKey, err := seal(key.aead, dataKey, []byte(key.id))
+	if err != nil {
+		return nil, err
+	}
+	return &Sealed{KeyID: key.id, Key: sealedKey, Data: sealedData}, nil
+}
+
+// Open decrypts the sealed value into v.
+func (r *KeyRing) Open(sealed *Sealed, associated []byte, v interface{}) error {
+	key := r.key(sealed.KeyID)
+	if key == nil {
+		return fmt.Errorf("%w %q", ErrUnknownKey, sealed.KeyID)
+	}
+	dataKey, err := open(key.aead, sealed.Key, []byte(key.id))
+	if err != nil {
+		return err
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+	plaintext, err := open(data, sealed.Data, associated)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(plaintext, v)
+}
+
+// active returns the key that seals values at the given time.
+func (r *KeyRing) active(at time.Time) (*encryptionKey, error) {
+	for i := len(r.keys) - 1; i >= 0; i-- {
+		if !r.keys[i].activeFrom.After(at) {
+			return &r.keys[i], nil
+		}
+	}
+	return nil, errors.New("no encryption key is active yet")
+}
+
+func (r *KeyRing) key(id string) *encryptionKey {
+	for i := range r.keys {
+		if r.keys[i].id == id {
+			return &r.keys[i]
+		}
+	}
+	return nil
+}
+
+func seal(aead cipher.AEAD, plaintext, associated []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, associated), nil
+}
+
+func open(aead cipher.AEAD, sealed, associated []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, associated)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,120 @@
+// Package events defines the events services exchange over Kafka: the envelope every event travels in,
+// and the schema of each event type. Producers and consumers share the payload types of this package,
+// so a change to an event is made once, and a consumer can tell which version of an event it was sent.
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+)
+
+var (
+	// ErrUnknownType is returned for payloads without a schema.
+	ErrUnknownType = errors.New("unknown event type")
+	// ErrWrongType is returned when decoding an event into the payload of another type.
+	ErrWrongType = errors.New("wrong event type")
+	// ErrIncompatible is returned for versions of an event the consumer cannot read: newer versions,
+	// and older ones without an upgrade to the version the consumer knows.
+	ErrIncompatible = errors.New("incompatible event version")
+)
+
+// Envelope wraps every event published to Kafka.
+type Envelope struct {
+	Type    string `json:"type"`
+	Version int    `json:"version"`
+	// Unique id of the event, so consumers can recognize an event delivered twice.
+	ID       string          `json:"id"`
+	Time     time.Time       `json:"time"`
+	Producer string          `json:"producer"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+// Payload is implemented by the payload types of the schemas.
+type Payload interface {
+	EventType() string
+}
+
+// Topic returns the topic events of the payload's type are published to.
+func Topic(payload Payload) (string, error) {
+	schema, err := schemaOf(payload)
+	if err != nil {
+		return "", err
+	}
+	return schema.Topic, nil
+}
+
+// Marshal wraps the payload in an envelope of the current version of its schema.
+func Marshal(producer config.Service, payload Payload) ([]byte, error) {
+	schema, err := schemaOf(payload)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Envelope{
+		Type:     schema.Type,
+		Version:  schema.Version,
+		ID:       id,
+		Time:     time.Now().UTC(),
+		Producer: string(producer),
+		Payload:  data,
+	})
+}
+
+// Decode reads an event into the payload and returns its envelope. Older versions of the event are
+// upgraded to the version of the payload; versions that cannot be are refused with ErrIncompatible.
+// Events published before events had envelopes are read as version 0.
+func Decode(data []byte, payload Payload) (*Envelope, error) {
+	schema, err := schemaOf(payload)
+	if err != nil {
+		return nil, err
+	}
+	var envelope Envelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, err
+	}
+	if envelope.Type == "" {
+		envelope = Envelope{Type: schema.Type, Payload: data}
+	}
+	if envelope.Type != schema.Type {
+		return nil, fmt.Errorf("%w %q, expected %q", ErrWrongType, envelope.Type, schema.Type)
+	}
+	if envelope.Version > schema.Version {
+		return nil, fmt.Errorf("%w: %s version %d is newer than %d", ErrIncompatible, schema.Type, envelope.Version, schema.Version)
+	}
+
+	raw := envelope.Payload
+	for version := envelope.Version; version < schema.Version; version++ {
+		upgrade, ok := schema.upgrades[version]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s version %d can no longer be read", ErrIncompatible, schema.Type, envelope.Version)
+		}
+		if raw, err = upgrade(raw); err != nil {
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal(raw, payload); err != nil {
+		return nil, err
+	}
+	return &envelope, nil
+}
+
+func newID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
@@ -0,0 +1,125 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/envelope"
+)
+
+// schema describes an event type: the topic it is published to, the version producers write, and how
+// consumers upgrade older versions, each to the next one. Fields may be added to a version without
+// changing it, as consumers ignore fields they do not know; any other change needs a new version.
+type schema struct {
+	Type     string
+	Topic    string
+	Version  int
+	upgrades map[int]func(json.RawMessage) (json.RawMessage, error)
+}
+
+// Event types.
+const (
+	UserAuthenticatedType = "user.authenticated"
+	SessionEndedType      = "user.session.ended"
+	AnomalyDetectedType   = "sales.anomaly.detected"
+)
+
+// Topics of the events.
+const (
+	AuthenticationsTopic = "user.authentications"
+	SessionsEndedTopic   = "user.sessions.expired"
+	AnomaliesTopic       = "sales.anomalies"
+)
+
+var schemas = map[string]*schema{
+	UserAuthenticatedType: {
+		Type:     UserAuthenticatedType,
+		Topic:    AuthenticationsTopic,
+		Version:  1,
+		upgrades: map[int]func(json.RawMessage) (json.RawMessage, error){0: unchanged},
+	},
+	SessionEndedType: {
+		Type:     SessionEndedType,
+		Topic:    SessionsEndedTopic,
+		Version:  1,
+		upgrades: map[int]func(json.RawMessage) (json.RawMessage, error){0: unchanged},
+	},
+	AnomalyDetectedType: {
+		Type:     AnomalyDetectedType,
+		Topic:    AnomaliesTopic,
+		Version:  1,
+		upgrades: map[int]func(json.RawMessage) (json.RawMessage, error){0: unchanged},
+	},
+}
+
+func schemaOf(payload Payload) (*schema, error) {
+	schema, ok := schemas[payload.EventType()]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownType, payload.EventType())
+	}
+	return schema, nil
+}
+
+// unchanged upgrades a version whose payload reads as the next version, such as version 0,
+// which is the payload of version 1 published without an envelope.
+func unchanged(payload json.RawMessage) (json.RawMessage, error) {
+	return payload, nil
+}
+
+// UserAuthenticated asks the scheduler to cache the sales of a publisher who logged in.
+// Published by the gateway.
+type UserAuthenticated struct {
+	Publisher string `json:"publisher"`
+	// SessionSecrets encrypted and bound to the publisher, so reading the topic does not let anyone
+	// log in to Unity as the publisher.
+	Secrets *envelope.Sealed `json:"secrets"`
+}
+
+func (UserAuthenticated) EventType() string { return UserAuthenticatedType }
+
+// SessionSecrets is the Unity session of a UserAuthenticated event.
+type SessionSecrets struct {
+	KharmaSession string `json:"kharmaSession"`
+	KharmaToken   string `json:"kharmaToken"`
+}
+
+// Reasons a session ends.
+const (
+	ReasonExpired   = "expired"
+	ReasonLogout    = "logout"
+	ReasonLogoutAll = "logout-all"
+)
+
+// SessionEnded tells consumers to stop using a session, because Unity rejected it or the user logged out.
+// Published by the gateway.
+type SessionEnded struct {
+	Publisher string `json:"publisher"`
+	// Fingerprint of the ended kharma session, so consumers only drop jobs of that session.
+	// Empty when every session of the publisher ended.
+	Session string `json:"session"`
+	// When every session ended, the time they ended: sessions the publisher logged in with later are still
+	// valid, even if their login is received after this event. Nil for a single session.
+	Before *time.Time `json:"before,omitempty"`
+	// Why the session ended, one of the Reason constants.
+	Reason string `json:"reason"`
+}
+
+func (SessionEnded) EventType() string { return SessionEndedType }
+
+// AnomalyDetected reports a package selling far more or less in a month than it usually does.
+// Published by the api-service, once per anomaly.
+type AnomalyDetected struct {
+	Publisher   string `json:"publisher"`
+	PackageId   string `json:"package_id"`
+	PackageName string `json:"package_name"`
+	Month       string `json:"month"`
+	// "spike" or "drop".
+	Kind        string  `json:"kind"`
+	UnitsToDate int     `json:"units_to_date"`
+	Projected   float64 `json:"projected_units"`
+	Baseline    float64 `json:"baseline_units"`
+	ZScore      float64 `json:"z_score"`
+}
+
+func (AnomalyDetected) EventType() string { return AnomalyDetectedType }
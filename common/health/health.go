@@ -0,0 +1,54 @@
+// Package health serves the liveness and readiness probes of the services.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Timeout bounds all readiness checks of a probe together.
+const Timeout = 2 * time.Second
+
+// Check is a dependency the service cannot serve requests without.
+type Check struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// Healthz serves the liveness probe, which succeeds as long as the process serves requests.
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	write(w, http.StatusOK, map[string]interface{}{"status": "ok"})
+}
+
+// Readyz serves the readiness probe: it runs every check and reports 503 if any of them fails.
+func Readyz(checks ...Check) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), Timeout)
+		defer cancel()
+
+		status := http.StatusOK
+		results := make(map[string]string, len(checks))
+		for _, check := range checks {
+			if err := check.Check(ctx); err != nil {
+				status = http.StatusServiceUnavailable
+				results[check.Name] = err.Error()
+				continue
+			}
+			results[check.Name] = "ok"
+		}
+
+		if status == http.StatusOK {
+			write(w, status, map[string]interface{}{"status": "ok", "checks": results})
+			return
+		}
+		write(w, status, map[string]interface{}{"status": "unavailable", "checks": results})
+	}
+}
+
+func write(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,121 @@
+// Package jwks encodes the gateway's token verification keys as JSON Web Keys (RFC 7517).
+package jwks
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Path the gateway publishes its key set at.
+const Path = "/.well-known/jwks.json"
+
+// Signing algorithms of the supported key types.
+const (
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+type Set struct {
+	Keys []Key `json:"keys"`
+}
+
+// Key is a public RSA or Ed25519 key.
+type Key struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+
+	// RSA modulus and exponent.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// Curve and public key of Ed25519 keys.
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// Find returns the key with the given id.
+func (s Set) Find(kid string) (Key, bool) {
+	for _, key := range s.Keys {
+		if key.Kid == kid {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+// Algorithm returns the algorithm tokens signed by the private half of the key use.
+func Algorithm(public crypto.PublicKey) (string, error) {
+	switch public.(type) {
+	case *rsa.PublicKey:
+		return RS256, nil
+	case ed25519.PublicKey:
+		return EdDSA, nil
+	default:
+		return "", fmt.Errorf("unsupported key type %T", public)
+	}
+}
+
+func NewKey(kid string, public crypto.PublicKey) (Key, error) {
+	encoding := base64.RawURLEncoding
+	switch public := public.(type) {
+	case *rsa.PublicKey:
+		return Key{
+			Kty: "RSA",
+			Kid: kid,
+			Alg: RS256,
+			Use: "sig",
+			N:   encoding.EncodeToString(public.N.Bytes()),
+			E:   encoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}, nil
+	case ed25519.PublicKey:
+		return Key{
+			Kty: "OKP",
+			Kid: kid,
+			Alg: EdDSA,
+			Use: "sig",
+			Crv: "Ed25519",
+			X:   encoding.EncodeToString(public),
+		}, nil
+	default:
+		return Key{}, fmt.Errorf("unsupported key type %T", public)
+	}
+}
+
+// PublicKey decodes the key into an *rsa.PublicKey or ed25519.PublicKey.
+func (k Key) PublicKey() (crypto.PublicKey, error) {
+	encoding := base64.RawURLEncoding
+	switch {
+	case k.Kty == "RSA" && k.Alg == RS256:
+		n, err := encoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus of key %s: %w", k.Kid, err)
+		}
+		e, err := encoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent of key %s: %w", k.Kid, err)
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid exponent of key %s", k.Kid)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case k.Kty == "OKP" && k.Crv == "Ed25519" && k.Alg == EdDSA:
+		x, err := encoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %s: %w", k.Kid, err)
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key size of key %s", k.Kid)
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, errors.New("unsupported key type " + k.Kty + "/" + k.Alg)
+	}
+}
@@ -0,0 +1,66 @@
+package jwks
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// LoadPrivateKey reads a PEM encoded PKCS #8 or PKCS #1 RSA or Ed25519 private key.
+func LoadPrivateKey(file string) (crypto.Signer, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var private interface{}
+	private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.New("not a PKCS #8 or PKCS #1 private key")
+		}
+	}
+
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		return private, nil
+	case ed25519.PrivateKey:
+		return private, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", private)
+	}
+}
+
+// LoadPublicKey reads a PEM encoded PKIX RSA or Ed25519 public key.
+func LoadPublicKey(file string) (crypto.PublicKey, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := Algorithm(public); err != nil {
+		return nil, err
+	}
+	return public, nil
+}
+
+func readPEM(file string) (*pem.Block, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	return block, nil
+}
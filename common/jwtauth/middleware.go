@@ -0,0 +1,101 @@
+package jwtauth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CookieName is the cookie the gateway stores user tokens in.
+const CookieName = "jwt"
+
+type contextKey int
+
+const (
+	claimsKey contextKey = iota
+	tokenKey
+)
+
+// Middleware rejects requests without a token in the Authorization header or jwt cookie that one of the verifiers accepts.
+// The claims and the token itself are added to the request context, so the token can be passed on
+// to other services.
+func Middleware(verifiers ...*Verifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := TokenFromRequest(c.Request)
+		if token == "" {
+			abort(c, http.StatusUnauthorized, "missing token")
+			return
+		}
+
+		var claims *Claims
+		var err error
+		for _, verifier := range verifiers {
+			if claims, err = verifier.Verify(c.Request.Context(), token); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			abort(c, http.StatusUnauthorized, "invalid token")
+			return
+		}
+
+		ctx := context.WithValue(c.Request.Context(), claimsKey, claims)
+		ctx = context.WithValue(ctx, tokenKey, token)
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// RequirePublisher rejects tokens issued to another publisher than the one named by the path parameter.
+// Must run after Middleware.
+func RequirePublisher(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := ClaimsFromContext(c.Request.Context())
+		if !ok || claims.Publisher == "" || claims.Publisher != c.Param(param) {
+			abort(c, http.StatusForbidden, "access to this publisher is not allowed")
+			return
+		}
+		c.Next()
+	}
+}
+
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey).(*Claims)
+	return claims, ok
+}
+
+// TokenFromContext returns the verified token of the request, or "" if there is none.
+func TokenFromContext(ctx context.Context) string {
+	token, _ := ctx.Value(tokenKey).(string)
+	return token
+}
+
+// SetBearer adds the token to the Authorization header of an outgoing request if it is not empty.
+func SetBearer(req *http.Request, token string) {
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// TokenFromRequest returns the unverified token of the Authorization header or jwt cookie, or "" if there is none.
+func TokenFromRequest(req *http.Request) string {
+	if header := req.Header.Get("Authorization"); header != "" {
+		if token, found := strings.CutPrefix(header, "Bearer "); found {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	if cookie, err := req.Cookie(CookieName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+func abort(c *gin.Context, status int, message string) {
+	c.AbortWithStatusJSON(status, gin.H{
+		"code":    status,
+		"message": message,
+	})
+}
@@ -0,0 +1,104 @@
+package jwtauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/jwks"
+)
+
+const (
+	// How long keys are cached if the key set does not say otherwise.
+	defaultKeyMaxAge = 5 * time.Minute
+	// Unknown key ids refetch the key set at most this often, so bogus tokens cannot flood the gateway.
+	minRefetchInterval = 30 * time.Second
+	fetchTimeout       = 5 * time.Second
+)
+
+// RemoteKeys fetches the key set published by the gateway and caches it for as long as the response allows.
+type RemoteKeys struct {
+	url    string
+	client *http.Client
+
+	mutex   sync.Mutex
+	set     jwks.Set
+	fetched time.Time
+	expires time.Time
+}
+
+func NewRemoteKeys(url string, client *http.Client) *RemoteKeys {
+	return &RemoteKeys{
+		url:    url,
+		client: client,
+	}
+}
+
+func (r *RemoteKeys) Key(ctx context.Context, kid string) (jwks.Key, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	key, found := r.set.Find(kid)
+	stale := now.After(r.expires)
+	if stale || (!found && now.Sub(r.fetched) > minRefetchInterval) {
+		if err := r.fetch(ctx); err != nil {
+			// Keep verifying with the cached keys while the gateway is unreachable.
+			if found {
+				return key, nil
+			}
+			return jwks.Key{}, fmt.Errorf("failed to fetch signing keys: %w", err)
+		}
+		key, found = r.set.Find(kid)
+	}
+	if !found {
+		return jwks.Key{}, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+func (r *RemoteKeys) fetch(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	var set jwks.Set
+	if err := json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	r.set = set
+	r.fetched = now
+	r.expires = now.Add(maxAge(res.Header.Get("Cache-Control")))
+	return nil
+}
+
+func maxAge(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.TrimSpace(directive)
+		if value, found := strings.CutPrefix(directive, "max-age="); found {
+			if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+				return time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return defaultKeyMaxAge
+}
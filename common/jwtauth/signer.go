@@ -0,0 +1,96 @@
+package jwtauth
+
+import (
+	"crypto"
+	"sync"
+	"time"
+
+	gojwt "github.com/golang-jwt/jwt/v4"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+	"github.com/Kwintenvdb/unity-publisher-management/common/jwks"
+)
+
+const (
+	serviceTokenLifetime = 5 * time.Minute
+	// Tokens are renewed this long before they expire, so they do not expire in flight.
+	serviceTokenRenewal = time.Minute
+)
+
+type serviceToken struct {
+	token   string
+	expires time.Time
+}
+
+// Signer issues short-lived tokens that identify a service to other services.
+// The tokens are signed with the service's private key under the service name as key id and issuer.
+type Signer struct {
+	service config.Service
+	key     crypto.Signer
+	method  gojwt.SigningMethod
+
+	mutex  sync.Mutex
+	tokens map[string]serviceToken
+}
+
+func NewSigner(service config.Service, keyFile string) (*Signer, error) {
+	key, err := jwks.LoadPrivateKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	algorithm, err := jwks.Algorithm(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{
+		service: service,
+		key:     key,
+		method:  gojwt.GetSigningMethod(algorithm),
+		tokens:  make(map[string]serviceToken),
+	}, nil
+}
+
+// Token returns a token for calls to the given service on behalf of the publisher, if any.
+// Tokens are reused until they are about to expire.
+func (s *Signer) Token(audience config.Service, publisher string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	cacheKey := string(audience) + "/" + publisher
+	if cached, ok := s.tokens[cacheKey]; ok && now.Before(cached.expires.Add(-serviceTokenRenewal)) {
+		return cached.token, nil
+	}
+
+	expires := now.Add(serviceTokenLifetime)
+	token := gojwt.NewWithClaims(s.method, Claims{
+		RegisteredClaims: gojwt.RegisteredClaims{
+			Issuer:    string(s.service),
+			Subject:   string(s.service),
+			Audience:  gojwt.ClaimStrings{string(audience)},
+			IssuedAt:  gojwt.NewNumericDate(now),
+			ExpiresAt: gojwt.NewNumericDate(expires),
+		},
+		Publisher: publisher,
+	})
+	token.Header["kid"] = string(s.service)
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		return "", err
+	}
+	s.tokens[cacheKey] = serviceToken{token: signed, expires: expires}
+	return signed, nil
+}
+
+// ServiceVerifier accepts the tokens the given service issues for the audience, signed by its public key file.
+func ServiceVerifier(service config.Service, keyFile string, audience config.Service) (*Verifier, error) {
+	public, err := jwks.LoadPublicKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := jwks.NewKey(string(service), public)
+	if err != nil {
+		return nil, err
+	}
+	return NewVerifier(StaticKeys(key), string(service), string(audience)), nil
+}
@@ -0,0 +1,84 @@
+// Package jwtauth verifies the tokens the gateway issues to users and the tokens services issue to each other.
+package jwtauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	gojwt "github.com/golang-jwt/jwt/v4"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/jwks"
+)
+
+// Claims of the tokens issued by the gateway and by services. Service tokens carry no email, and only
+// carry a publisher when the service acts on behalf of one.
+type Claims struct {
+	gojwt.RegisteredClaims
+	Email     string `json:"email,omitempty"`
+	Publisher string `json:"publisher,omitempty"`
+}
+
+// KeySource looks up verification keys by key id.
+type KeySource interface {
+	Key(ctx context.Context, kid string) (jwks.Key, error)
+}
+
+type staticKeys jwks.Set
+
+// StaticKeys is a KeySource of a fixed set of keys.
+func StaticKeys(keys ...jwks.Key) KeySource {
+	return staticKeys{Keys: keys}
+}
+
+func (s staticKeys) Key(ctx context.Context, kid string) (jwks.Key, error) {
+	if key, ok := jwks.Set(s).Find(kid); ok {
+		return key, nil
+	}
+	return jwks.Key{}, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// Verifier accepts tokens signed by one of its keys for the given issuer and audience.
+type Verifier struct {
+	keys     KeySource
+	issuer   string
+	audience string
+}
+
+func NewVerifier(keys KeySource, issuer, audience string) *Verifier {
+	return &Verifier{
+		keys:     keys,
+		issuer:   issuer,
+		audience: audience,
+	}
+}
+
+// Verify checks the signature, expiry, issuer and audience of the token and returns its claims.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := gojwt.ParseWithClaims(token, claims, func(t *gojwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := v.keys.Key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		if t.Method.Alg() != key.Alg {
+			return nil, fmt.Errorf("token of key %q is not signed with %s", kid, key.Alg)
+		}
+		return key.PublicKey()
+	}, gojwt.WithValidMethods([]string{jwks.RS256, jwks.EdDSA}))
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("token does not expire")
+	}
+	if !claims.VerifyIssuer(v.issuer, true) {
+		return nil, fmt.Errorf("token is not issued by %s", v.issuer)
+	}
+	if !claims.VerifyAudience(v.audience, true) {
+		return nil, fmt.Errorf("token is not meant for %s", v.audience)
+	}
+	return claims, nil
+}
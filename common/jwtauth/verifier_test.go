@@ -0,0 +1,122 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gojwt "github.com/golang-jwt/jwt/v4"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/jwks"
+)
+
+func TestMiddlewareKeyIds(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	current, currentKey := testKey(t, "current")
+	retired, _ := testKey(t, "retired")
+	verifier := NewVerifier(StaticKeys(currentKey), "gateway", "api")
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"current key", testToken(t, "current", current), http.StatusOK},
+		{"unknown key id", testToken(t, "retired", retired), http.StatusUnauthorized},
+		{"no key id", testToken(t, "", current), http.StatusUnauthorized},
+		{"key id of another key", testToken(t, "current", retired), http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/", Middleware(verifier), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			SetBearer(req, test.token)
+			r.ServeHTTP(w, req)
+			if w.Code != test.status {
+				t.Errorf("status = %d, want %d", w.Code, test.status)
+			}
+		})
+	}
+}
+
+func TestRemoteKeysRotation(t *testing.T) {
+	old, oldKey := testKey(t, "old")
+	rotated, rotatedKey := testKey(t, "rotated")
+	tests := []struct {
+		name         string
+		cacheControl string
+		verified     bool
+	}{
+		// The cached key set has expired, so the key set with the new key is fetched.
+		{"expired key set", "max-age=0", true},
+		// Unknown keys refetch the key set, but not within minRefetchInterval of the last fetch.
+		{"recently fetched key set", "max-age=300", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var mutex sync.Mutex
+			set := jwks.Set{Keys: []jwks.Key{oldKey}}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				w.Header().Set("Cache-Control", test.cacheControl)
+				json.NewEncoder(w).Encode(set)
+			}))
+			defer server.Close()
+			verifier := NewVerifier(NewRemoteKeys(server.URL, server.Client()), "gateway", "api")
+
+			if _, err := verifier.Verify(context.Background(), testToken(t, "old", old)); err != nil {
+				t.Fatalf("token of the old key: %v", err)
+			}
+			mutex.Lock()
+			set = jwks.Set{Keys: []jwks.Key{rotatedKey}}
+			mutex.Unlock()
+			_, err := verifier.Verify(context.Background(), testToken(t, "rotated", rotated))
+			if verified := err == nil; verified != test.verified {
+				t.Errorf("token of the rotated key verified = %v (%v), want %v", verified, err, test.verified)
+			}
+		})
+	}
+}
+
+func testKey(t *testing.T, kid string) (ed25519.PrivateKey, jwks.Key) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := jwks.NewKey(kid, public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return private, key
+}
+
+// testToken returns a token for the api, issued by the gateway and signed by the private key. An empty kid
+// leaves the key id out.
+func testToken(t *testing.T, kid string, private ed25519.PrivateKey) string {
+	t.Helper()
+	token := gojwt.NewWithClaims(gojwt.SigningMethodEdDSA, Claims{RegisteredClaims: gojwt.RegisteredClaims{
+		Issuer:    "gateway",
+		Audience:  gojwt.ClaimStrings{"api"},
+		ExpiresAt: gojwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
@@ -0,0 +1,47 @@
+// Package lifecycle runs the HTTP servers of the services until they are asked to stop.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/logger"
+)
+
+// Serve runs the server until SIGINT or SIGTERM, then stops accepting connections and waits for in-flight
+// requests and then the background work, if any, such as publishing queued events or flushing traces.
+// Both share one shutdown deadline.
+func Serve(srv *http.Server, timeout time.Duration, log logger.Logger, background func(ctx context.Context) error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalw("Server failed", "error", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+
+	log.Infow("Shutting down...", "timeout", timeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Errorw("Failed to drain requests", "error", err)
+	}
+	if background != nil {
+		if err := background(shutdownCtx); err != nil {
+			log.Errorw("Failed to finish background work", "error", err)
+		}
+	}
+	log.Info("Shutdown complete")
+	log.Sync()
+}
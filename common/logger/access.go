@@ -0,0 +1,40 @@
+package logger
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AccessLog logs one structured line per request, replacing the default gin logger.
+// It must run after requestid.Middleware to include the request id.
+func AccessLog(l Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+
+		c.Next()
+
+		keysAndValues := []interface{}{
+			"method", c.Request.Method,
+			"path", path,
+			"status", c.Writer.Status(),
+			"latency", time.Since(start),
+			"bytes", c.Writer.Size(),
+			"client_ip", c.ClientIP(),
+		}
+		if len(c.Errors) > 0 {
+			keysAndValues = append(keysAndValues, "errors", c.Errors.String())
+		}
+
+		log := ForContext(l, c.Request.Context())
+		switch {
+		case c.Writer.Status() >= 500:
+			log.Errorw("Request", keysAndValues...)
+		case c.Writer.Status() >= 400:
+			log.Warnw("Request", keysAndValues...)
+		default:
+			log.Infow("Request", keysAndValues...)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package logger
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/requestid"
+)
+
+type Logger interface {
+	Debug(args ...interface{})
+	Info(args ...interface{})
+	Warn(args ...interface{})
+	Error(args ...interface{})
+	Fatal(args ...interface{})
+
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+	Fatalw(msg string, keysAndValues ...interface{})
+
+	// With returns a logger that adds the given key-value pairs to every line.
+	With(keysAndValues ...interface{}) Logger
+
+	Sync() error
+}
+
+type sugaredLogger struct {
+	*zap.SugaredLogger
+}
+
+func (l sugaredLogger) With(keysAndValues ...interface{}) Logger {
+	return sugaredLogger{l.SugaredLogger.With(keysAndValues...)}
+}
+
+// Wrap the zap logger into our own interface for easier drop-in replacement and testing.
+func NewLogger() Logger {
+	zapLogger, _ := zap.NewDevelopment()
+	return sugaredLogger{zapLogger.Sugar()}
+}
+
+// ForContext returns a logger that tags every line with the request id of the context, if any.
+func ForContext(l Logger, ctx context.Context) Logger {
+	if id := requestid.FromContext(ctx); id != "" {
+		return l.With("request_id", id)
+	}
+	return l
+}
@@ -0,0 +1,244 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// Kafka exchanges messages over Kafka brokers.
+type Kafka struct {
+	brokers []string
+}
+
+func NewKafka(brokers []string) *Kafka {
+	return &Kafka{brokers: brokers}
+}
+
+// Publisher returns a publisher whose messages are acknowledged by every in-sync replica.
+func (k *Kafka) Publisher() Publisher {
+	return &kafkaPublisher{writer: &kafka.Writer{
+		Addr: kafka.TCP(k.brokers...),
+		// Messages with the same key go to the same partition, which keeps them in order.
+		Balancer:        &kafka.Hash{},
+		RequiredAcks:    kafka.RequireAll,
+		MaxAttempts:     5,
+		WriteBackoffMin: 100 * time.Millisecond,
+		WriteBackoffMax: time.Second,
+		// Messages are mostly published one at a time, so there is no batch worth waiting for.
+		BatchTimeout: 10 * time.Millisecond,
+	}}
+}
+
+// Subscribe joins the consumer group of the topic. A new group starts at the oldest message of the topic.
+func (k *Kafka) Subscribe(topic, group string) Subscriber {
+	return &kafkaSubscriber{reader: kafka.NewReader(kafka.ReaderConfig{
+		Brokers: k.brokers,
+		Topic:   topic,
+		GroupID: group,
+	})}
+}
+
+// Browse reads every partition of the topic from its first offset. Partitions added later are not read.
+func (k *Kafka) Browse(topic string) Subscriber {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &kafkaBrowser{
+		brokers:  k.brokers,
+		topic:    topic,
+		messages: make(chan kafka.Message),
+		errs:     make(chan error, 1),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Ping checks that every broker accepts connections.
+func (k *Kafka) Ping(ctx context.Context) error {
+	for _, broker := range k.brokers {
+		conn, err := kafka.DialContext(ctx, "tcp", broker)
+		if err != nil {
+			return err
+		}
+		conn.Close()
+	}
+	return nil
+}
+
+type kafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+func (p *kafkaPublisher) Publish(ctx context.Context, messages ...Message) error {
+	written := make([]kafka.Message, len(messages))
+	for i, m := range messages {
+		written[i] = kafka.Message{Topic: m.Topic, Key: m.Key, Value: m.Value}
+		for key, value := range m.Headers {
+			written[i].Headers = append(written[i].Headers, kafka.Header{Key: key, Value: []byte(value)})
+		}
+	}
+	return p.writer.WriteMessages(ctx, written...)
+}
+
+func (p *kafkaPublisher) Close() error {
+	return p.writer.Close()
+}
+
+type kafkaSubscriber struct {
+	reader *kafka.Reader
+}
+
+func (s *kafkaSubscriber) Receive(ctx context.Context) (Message, error) {
+	m, err := s.reader.FetchMessage(ctx)
+	if err != nil {
+		return Message{}, err
+	}
+	headers := make(Headers, len(m.Headers))
+	for _, header := range m.Headers {
+		headers[header.Key] = string(header.Value)
+	}
+	return Message{Topic: m.Topic, Key: m.Key, Value: m.Value, Headers: headers, delivery: m}, nil
+}
+
+// Ack commits the offset of the message for the group.
+func (s *kafkaSubscriber) Ack(ctx context.Context, m Message) error {
+	return s.reader.CommitMessages(ctx, m.delivery.(kafka.Message))
+}
+
+// Close leaves the consumer group.
+func (s *kafkaSubscriber) Close() error {
+	return s.reader.Close()
+}
+
+type kafkaBrowser struct {
+	brokers  []string
+	topic    string
+	messages chan kafka.Message
+	errs     chan error
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mutex   sync.Mutex
+	readers []*kafka.Reader
+	wg      sync.WaitGroup
+}
+
+func (b *kafkaBrowser) Receive(ctx context.Context) (Message, error) {
+	if err := b.start(ctx); err != nil {
+		return Message{}, err
+	}
+	select {
+	case m := <-b.messages:
+		headers := make(Headers, len(m.Headers))
+		for _, header := range m.Headers {
+			headers[header.Key] = string(header.Value)
+		}
+		return Message{Topic: m.Topic, Key: m.Key, Value: m.Value, Headers: headers}, nil
+	case err := <-b.errs:
+		return Message{}, err
+	case <-b.ctx.Done():
+		return Message{}, ErrClosed
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	}
+}
+
+// start opens a reader for every partition of the topic, unless it did already. It fails while the topic
+// has no partitions, such as before its first message, so that the next Receive tries again.
+func (b *kafkaBrowser) start(ctx context.Context) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.ctx.Err() != nil {
+		return ErrClosed
+	}
+	if b.readers != nil {
+		return nil
+	}
+
+	partitions, err := b.partitions(ctx)
+	if err != nil {
+		return err
+	}
+	if len(partitions) == 0 {
+		return fmt.Errorf("topic %s has no partitions yet", b.topic)
+	}
+	for _, partition := range partitions {
+		reader := kafka.NewReader(kafka.ReaderConfig{
+			Brokers:   b.brokers,
+			Topic:     b.topic,
+			Partition: partition.ID,
+		})
+		b.readers = append(b.readers, reader)
+		b.wg.Add(1)
+		go b.read(reader)
+	}
+	return nil
+}
+
+func (b *kafkaBrowser) partitions(ctx context.Context) ([]kafka.Partition, error) {
+	var err error
+	for _, broker := range b.brokers {
+		var conn *kafka.Conn
+		conn, err = kafka.DialContext(ctx, "tcp", broker)
+		if err != nil {
+			continue
+		}
+		var partitions []kafka.Partition
+		partitions, err = conn.ReadPartitions(b.topic)
+		conn.Close()
+		if err == nil {
+			return partitions, nil
+		}
+	}
+	return nil, err
+}
+
+func (b *kafkaBrowser) read(reader *kafka.Reader) {
+	defer b.wg.Done()
+	for {
+		m, err := reader.ReadMessage(b.ctx)
+		if err != nil {
+			if b.ctx.Err() != nil {
+				return
+			}
+			select {
+			case b.errs <- err:
+			default:
+			}
+			// The reader retries on its own, so errors it returns are rarely worth retrying at once.
+			select {
+			case <-time.After(time.Second):
+			case <-b.ctx.Done():
+				return
+			}
+			continue
+		}
+		select {
+		case b.messages <- m:
+		case <-b.ctx.Done():
+			return
+		}
+	}
+}
+
+// Ack has nothing to do, as browsing commits no offsets.
+func (b *kafkaBrowser) Ack(ctx context.Context, m Message) error {
+	return nil
+}
+
+func (b *kafkaBrowser) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.cancel()
+	b.wg.Wait()
+	var err error
+	for _, reader := range b.readers {
+		if closeErr := reader.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,155 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Messages a consumer group holds before publishing to it waits.
+const memoryBuffer = 1000
+
+// Memory passes messages between the publishers and subscribers of the process over channels, one for each
+// consumer group of a topic. Messages are only kept until they are received: messages published to a topic
+// without subscribers are lost, as are all messages when the process stops.
+type Memory struct {
+	mutex    sync.Mutex
+	groups   map[string]map[string]*memoryGroup // By topic and group.
+	browsers int
+}
+
+type memoryGroup struct {
+	messages    chan Message
+	subscribers int
+}
+
+func NewMemory() *Memory {
+	return &Memory{groups: make(map[string]map[string]*memoryGroup)}
+}
+
+func (m *Memory) Publisher() Publisher {
+	return memoryPublisher{memory: m}
+}
+
+// Subscribe joins the consumer group of the topic, sharing its messages with the other subscribers of the group.
+func (m *Memory) Subscribe(topic, group string) Subscriber {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.subscribe(topic, group)
+}
+
+// Browse receives the messages published to the topic from now on, as earlier ones are not kept.
+func (m *Memory) Browse(topic string) Subscriber {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.browsers++
+	return m.subscribe(topic, fmt.Sprintf("browser-%d", m.browsers))
+}
+
+func (m *Memory) subscribe(topic, group string) *memorySubscriber {
+	groups, ok := m.groups[topic]
+	if !ok {
+		groups = make(map[string]*memoryGroup)
+		m.groups[topic] = groups
+	}
+	g, ok := groups[group]
+	if !ok {
+		g = &memoryGroup{messages: make(chan Message, memoryBuffer)}
+		groups[group] = g
+	}
+	g.subscribers++
+	return &memorySubscriber{
+		messages: g.messages,
+		closed:   make(chan struct{}),
+		leave:    func() { m.leave(topic, group) },
+	}
+}
+
+// leave removes a subscriber from its group. Groups without subscribers stop receiving messages.
+func (m *Memory) leave(topic, group string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	g := m.groups[topic][group]
+	g.subscribers--
+	if g.subscribers == 0 {
+		delete(m.groups[topic], group)
+	}
+}
+
+func (m *Memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *Memory) subscribers(topic string) []chan Message {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var channels []chan Message
+	for _, g := range m.groups[topic] {
+		channels = append(channels, g.messages)
+	}
+	return channels
+}
+
+type memoryPublisher struct {
+	memory *Memory
+}
+
+// Publish hands every message to every consumer group of its topic, waiting while a group is full.
+func (p memoryPublisher) Publish(ctx context.Context, messages ...Message) error {
+	for _, m := range messages {
+		for _, group := range p.memory.subscribers(m.Topic) {
+			// Every group gets headers of its own, as consumers may add to them.
+			received := m
+			received.Headers = make(Headers, len(m.Headers))
+			for key, value := range m.Headers {
+				received.Headers[key] = value
+			}
+			select {
+			case group <- received:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+func (p memoryPublisher) Close() error {
+	return nil
+}
+
+type memorySubscriber struct {
+	messages  chan Message
+	closed    chan struct{}
+	closeOnce sync.Once
+	leave     func()
+}
+
+func (s *memorySubscriber) Receive(ctx context.Context) (Message, error) {
+	select {
+	case <-s.closed:
+		return Message{}, ErrClosed
+	default:
+	}
+	select {
+	case m := <-s.messages:
+		return m, nil
+	case <-s.closed:
+		return Message{}, ErrClosed
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	}
+}
+
+// Ack has nothing to do, as messages are removed from the group once received.
+func (s *memorySubscriber) Ack(ctx context.Context, m Message) error {
+	return nil
+}
+
+func (s *memorySubscriber) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		s.leave()
+	})
+	return nil
+}
@@ -0,0 +1,134 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryDeliversToEveryGroup(t *testing.T) {
+	broker := NewMemory()
+	first := broker.Subscribe("topic", "first")
+	second := broker.Subscribe("topic", "second")
+	other := broker.Subscribe("other", "first")
+
+	ctx := context.Background()
+	m := Message{Topic: "topic", Key: []byte("key"), Value: []byte("value"), Headers: Headers{"header": "value"}}
+	if err := broker.Publisher().Publish(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, subscriber := range []Subscriber{first, second} {
+		received, err := subscriber.Receive(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(received.Key) != "key" || string(received.Value) != "value" || received.Headers["header"] != "value" {
+			t.Errorf("received %+v, want %+v", received, m)
+		}
+		if err := subscriber.Ack(ctx, received); err != nil {
+			t.Error(err)
+		}
+	}
+	if received, err := receiveWithin(other, 10*time.Millisecond); err == nil {
+		t.Errorf("other topic received %+v", received)
+	}
+}
+
+func TestMemorySharesMessagesWithinGroup(t *testing.T) {
+	broker := NewMemory()
+	first := broker.Subscribe("topic", "group")
+	second := broker.Subscribe("topic", "group")
+
+	ctx := context.Background()
+	if err := broker.Publisher().Publish(ctx, Message{Topic: "topic", Value: []byte("1")}, Message{Topic: "topic", Value: []byte("2")}); err != nil {
+		t.Fatal(err)
+	}
+	a, err := first.Receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := second.Receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(a.Value) != "1" || string(b.Value) != "2" {
+		t.Errorf("received %q and %q, want 1 and 2", a.Value, b.Value)
+	}
+}
+
+func TestMemoryHeadersAreCopiedPerGroup(t *testing.T) {
+	broker := NewMemory()
+	first := broker.Subscribe("topic", "first")
+	second := broker.Subscribe("topic", "second")
+
+	ctx := context.Background()
+	if err := broker.Publisher().Publish(ctx, Message{Topic: "topic", Headers: Headers{"header": "value"}}); err != nil {
+		t.Fatal(err)
+	}
+	a, _ := first.Receive(ctx)
+	a.Headers["header"] = "changed"
+	b, _ := second.Receive(ctx)
+	if b.Headers["header"] != "value" {
+		t.Errorf("header = %q, want value", b.Headers["header"])
+	}
+}
+
+func TestMemoryClose(t *testing.T) {
+	broker := NewMemory()
+	subscriber := broker.Subscribe("topic", "group")
+	if err := subscriber.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := subscriber.Receive(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("err = %v, want ErrClosed", err)
+	}
+
+	// The group left with its last subscriber, so publishing does not wait for it.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i := 0; i < memoryBuffer+1; i++ {
+		if err := broker.Publisher().Publish(ctx, Message{Topic: "topic"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMemoryBrowse(t *testing.T) {
+	broker := NewMemory()
+	first := broker.Browse("topic")
+	second := broker.Browse("topic")
+
+	ctx := context.Background()
+	if err := broker.Publisher().Publish(ctx, Message{Topic: "topic", Value: []byte("value")}); err != nil {
+		t.Fatal(err)
+	}
+	for _, browser := range []Subscriber{first, second} {
+		if m, err := browser.Receive(ctx); err != nil || string(m.Value) != "value" {
+			t.Errorf("received %q, %v, want value", m.Value, err)
+		}
+	}
+}
+
+func TestMemoryPublishWaitsForFullGroup(t *testing.T) {
+	broker := NewMemory()
+	broker.Subscribe("topic", "group")
+	for i := 0; i < memoryBuffer; i++ {
+		if err := broker.Publisher().Publish(context.Background(), Message{Topic: "topic"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := broker.Publisher().Publish(ctx, Message{Topic: "topic"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func receiveWithin(subscriber Subscriber, timeout time.Duration) (Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return subscriber.Receive(ctx)
+}
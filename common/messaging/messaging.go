@@ -0,0 +1,65 @@
+// Package messaging publishes and receives the messages services exchange, over Kafka or within the process.
+// Producers and consumers depend on the interfaces of this package only, so they run the same on either.
+package messaging
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/config"
+)
+
+// ErrClosed is returned when receiving from a closed subscriber.
+var ErrClosed = errors.New("subscriber is closed")
+
+// Headers carry the metadata of a message, such as its request id and trace context.
+type Headers map[string]string
+
+// Message is a message of a topic. Messages with the same key are received in the order they were published.
+type Message struct {
+	Topic   string
+	Key     []byte
+	Value   []byte
+	Headers Headers
+
+	// delivery identifies a received message to the subscriber that acknowledges it.
+	delivery interface{}
+}
+
+// Publisher publishes messages to their topics.
+type Publisher interface {
+	// Publish returns once the broker accepted every message, or fails if any was not.
+	Publish(ctx context.Context, messages ...Message) error
+	Close() error
+}
+
+// Subscriber receives the messages of a topic on behalf of a consumer group. Each message of the topic is
+// received by one subscriber of every group.
+type Subscriber interface {
+	// Receive waits for the next message.
+	Receive(ctx context.Context) (Message, error)
+	// Ack marks a received message, and those received before it, as handled. Messages that were
+	// not acknowledged may be received again once the group restarts.
+	Ack(ctx context.Context, m Message) error
+	Close() error
+}
+
+// Broker connects publishers and subscribers.
+type Broker interface {
+	Publisher() Publisher
+	Subscribe(topic, group string) Subscriber
+	// Browse receives the messages of the topic from the oldest one still held, outside of any consumer
+	// group, so every browser receives all of them. Acknowledging them has no effect. Receiving fails while
+	// the topic does not exist yet, and is to be retried like other failures to receive.
+	Browse(topic string) Subscriber
+	// Ping checks that the broker is reachable.
+	Ping(ctx context.Context) error
+}
+
+// New returns the broker of the configured driver.
+func New(cfg config.KafkaConfig) Broker {
+	if cfg.Driver == "memory" {
+		return NewMemory()
+	}
+	return NewKafka(cfg.Brokers)
+}
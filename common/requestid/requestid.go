@@ -0,0 +1,63 @@
+// Package requestid correlates the log lines of a single request across services.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Header carries the request id over HTTP and Kafka.
+const Header = "X-Request-ID"
+
+// Incoming ids longer than this are replaced rather than trusted.
+const maxLength = 128
+
+type contextKey struct{}
+
+// New returns a random request id.
+func New() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request id of the context, or an empty string if there is none.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Middleware accepts the X-Request-ID of the caller or generates one. The id is stored in the request
+// context, echoed in the response and kept on the request headers so proxied requests carry it along.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(Header)
+		if !valid(id) {
+			id = New()
+		}
+
+		c.Request.Header.Set(Header, id)
+		c.Request = c.Request.WithContext(NewContext(c.Request.Context(), id))
+		c.Writer.Header().Set(Header, id)
+		c.Next()
+	}
+}
+
+func valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,18 @@
+// Package session describes how services recognize the Unity session of a publisher. Ended sessions are
+// announced with events.SessionEnded.
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// ExpiredHeader is set by the api-service on 401 responses caused by Unity rejecting the kharma session,
+// as opposed to the api-service rejecting the token.
+const ExpiredHeader = "X-Unity-Session-Expired"
+
+// Fingerprint identifies a kharma session without revealing it.
+func Fingerprint(kharmaSession string) string {
+	sum := sha256.Sum256([]byte(kharmaSession))
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,41 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/Kwintenvdb/unity-publisher-management/common/messaging"
+)
+
+const messagingScope = "github.com/Kwintenvdb/unity-publisher-management/common/tracing"
+
+// StartPublish starts a producer span for a message to topic and injects its trace context into headers.
+func StartPublish(ctx context.Context, topic string, headers messaging.Headers) (context.Context, trace.Span) {
+	ctx, span := Tracer(messagingScope).Start(ctx, topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystem("kafka"),
+			semconv.MessagingDestinationName(topic),
+			semconv.MessagingOperationPublish,
+		),
+	)
+	Inject(ctx, propagation.MapCarrier(headers))
+	return ctx, span
+}
+
+// StartConsume continues the trace found in the headers of m with a consumer span.
+func StartConsume(ctx context.Context, m messaging.Message) (context.Context, trace.Span) {
+	ctx = Extract(ctx, propagation.MapCarrier(m.Headers))
+	return Tracer(messagingScope).Start(ctx, m.Topic+" receive",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystem("kafka"),
+			semconv.MessagingDestinationName(m.Topic),
+			semconv.MessagingOperationReceive,
+			semconv.MessagingKafkaMessageKey(string(m.Key)),
+		),
+	)
+}